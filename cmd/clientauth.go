@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Client authentication methods for the token endpoint,
+// https://openid.net/specs/openid-connect-core-1_0.html#ClientAuthentication
+// and https://tools.ietf.org/html/rfc8705#section-2
+const (
+	authSecretBasic    = "client_secret_basic"
+	authSecretPost     = "client_secret_post"
+	authSecretJWT      = "client_secret_jwt"
+	authPrivateKeyJWT  = "private_key_jwt"
+	authTLS            = "tls_client_auth"
+	authSelfSignedTLS  = "self_signed_tls_client_auth"
+	authNone           = "none"
+	clientAssertionJWT = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+// clientAuth returns the configured client authentication method. The
+// default is client_secret_basic when there is a secret, otherwise none.
+func (cfg *TestConfig) clientAuth() string {
+	switch {
+	case cfg.ClientAuth != "":
+		return cfg.ClientAuth
+	case cfg.ClientSecret != "":
+		return authSecretBasic
+	default:
+		return authNone
+	}
+}
+
+func (cfg *TestConfig) validateClientAuth() []string {
+	var err []string
+
+	switch cfg.clientAuth() {
+	case authSecretBasic, authSecretPost, authSecretJWT:
+		if cfg.ClientSecret == "" {
+			err = append(err, fmt.Sprintf("clientSecret is required for clientAuth [%s]", cfg.clientAuth()))
+		}
+	case authPrivateKeyJWT:
+		if cfg.ClientAssertionKey == "" {
+			err = append(err, fmt.Sprintf("clientAssertionKey is required for clientAuth [%s]", cfg.clientAuth()))
+		}
+	case authTLS, authSelfSignedTLS:
+		if cfg.ClientCert == "" {
+			err = append(err, fmt.Sprintf("clientCert is required for clientAuth [%s]", cfg.clientAuth()))
+		}
+	case authNone:
+	default:
+		err = append(err, fmt.Sprintf("clientAuth [%s] is invalid, expected one of %s", cfg.ClientAuth, strings.Join([]string{
+			authSecretBasic, authSecretPost, authSecretJWT, authPrivateKeyJWT, authTLS, authSelfSignedTLS, authNone,
+		}, ", ")))
+	}
+
+	return err
+}
+
+// authenticateClient adds the client credentials to the form posted to the
+// endpoint, client_secret_basic is sent in the Authorization header by
+// postForm instead. The decoded client assertion is recorded in report.
+func authenticateClient(cfg *TestConfig, endpoint string, form url.Values, report *EndpointReport) error {
+	switch cfg.clientAuth() {
+	case authSecretBasic:
+
+	case authSecretPost:
+		form.Set("client_id", cfg.ClientID)
+		form.Set("client_secret", cfg.ClientSecret)
+
+	case authSecretJWT, authPrivateKeyJWT:
+		assertion, err := clientAssertion(cfg, endpoint)
+		if err != nil {
+			return fmt.Errorf("error creating client assertion: %w", err)
+		}
+		report.ClientAssertion = decodeToken(assertion)
+
+		form.Set("client_id", cfg.ClientID)
+		form.Set("client_assertion_type", clientAssertionJWT)
+		form.Set("client_assertion", assertion)
+
+	default:
+		// none and the TLS methods identify the client by client_id,
+		// the TLS methods authenticate with the client certificate.
+		form.Set("client_id", cfg.ClientID)
+	}
+
+	return nil
+}
+
+// clientAssertion creates the JWT for client_secret_jwt or private_key_jwt,
+// the audience is the endpoint it is sent to,
+// https://tools.ietf.org/html/rfc7523#section-3
+func clientAssertion(cfg *TestConfig, endpoint string) (string, error) {
+	var key jose.SigningKey
+	opts := new(jose.SignerOptions).WithType("JWT")
+
+	if cfg.clientAuth() == authSecretJWT {
+		key = jose.SigningKey{Algorithm: jose.HS256, Key: []byte(cfg.ClientSecret)}
+		if cfg.ClientAssertionAlg != "" {
+			key.Algorithm = jose.SignatureAlgorithm(cfg.ClientAssertionAlg)
+		}
+	} else {
+		signing, err := loadPrivateKey("clientAssertionKey", cfg.ClientAssertionKey)
+		if err != nil {
+			return "", err
+		}
+
+		if cfg.ClientAssertionKeyID != "" {
+			signing.jwk.KeyID = cfg.ClientAssertionKeyID
+		}
+
+		alg := cfg.ClientAssertionAlg
+		if alg == "" {
+			alg = signing.jwk.Algorithm
+		}
+		if alg == "" {
+			alg, err = defaultAlgorithm(signing.jwk.Key)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		key = jose.SigningKey{Algorithm: jose.SignatureAlgorithm(alg), Key: signing.jwk}
+
+		// Some providers, such as Azure AD, find the key by the SHA-1
+		// thumbprint of the certificate rather than a kid.
+		if signing.cert != nil {
+			sum := sha1.Sum(signing.cert.Raw)
+			opts = opts.WithHeader("x5t", base64.RawURLEncoding.EncodeToString(sum[:]))
+		}
+	}
+
+	signer, err := jose.NewSigner(key, opts)
+	if err != nil {
+		return "", fmt.Errorf("could not create signer: %w", err)
+	}
+
+	jti, err := randomString(16)
+	if err != nil {
+		return "", fmt.Errorf("could not generate jti: %w", err)
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:   cfg.ClientID,
+		Subject:  cfg.ClientID,
+		Audience: jwt.Audience{endpoint},
+		ID:       jti,
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(5 * time.Minute)),
+	}
+
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// privateKey is a private key for private_key_jwt or decryption with the
+// certificate when one was in the same PEM file.
+type privateKey struct {
+	jwk  jose.JSONWebKey
+	cert *x509.Certificate
+}
+
+// loadPrivateKey reads a private key from a JWK or a PEM file, the PEM
+// file may also contain the key's certificate. The option is the name of
+// the config option used in errors.
+func loadPrivateKey(option, path string) (*privateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", option, err)
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		k := &privateKey{}
+		err = json.Unmarshal(data, &k.jwk)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s JWK: %w", option, err)
+		}
+		if k.jwk.IsPublic() {
+			return nil, fmt.Errorf("%s JWK is a public key, a private key is required", option)
+		}
+		return k, nil
+	}
+
+	k := &privateKey{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			if k.cert == nil {
+				k.cert, err = x509.ParseCertificate(block.Bytes)
+			}
+		case "PRIVATE KEY":
+			k.jwk.Key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			k.jwk.Key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			k.jwk.Key, err = x509.ParseECPrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s %s: %w", option, block.Type, err)
+		}
+	}
+
+	if k.jwk.Key == nil {
+		return nil, fmt.Errorf("%s [%s] does not contain a JWK or PEM private key", option, path)
+	}
+
+	return k, nil
+}
+
+// defaultAlgorithm picks the signing algorithm for the type of key.
+func defaultAlgorithm(key crypto.PrivateKey) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return string(jose.RS256), nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return string(jose.ES256), nil
+		case elliptic.P384():
+			return string(jose.ES384), nil
+		case elliptic.P521():
+			return string(jose.ES512), nil
+		}
+		return "", fmt.Errorf("unsupported curve [%s], set clientAssertionAlg", k.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return string(jose.EdDSA), nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T, set clientAssertionAlg", key)
+	}
+}
+
+// checkClientAuth reports if the provider advertised the client
+// authentication method and, for the JWT methods, the signing algorithm.
+func checkClientAuth(method string, assertion *TokenReport, m ProviderMetadata) []Check {
+	const name = "client_auth"
+
+	var checks []Check
+	switch {
+	case m.TokenEndpointAuthMethods == nil:
+		// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+		// defaults to client_secret_basic when omitted.
+		switch method {
+		case authSecretBasic:
+			checks = append(checks, pass(name, "[%s] is the default as token_endpoint_auth_methods_supported is not advertised", method))
+		case authNone:
+			// A public client does not authenticate, so there is no
+			// method for the provider to support.
+			checks = append(checks, pass(name, "[%s] the client does not authenticate, token_endpoint_auth_methods_supported is not advertised", method))
+		default:
+			checks = append(checks, fail(name, "[%s] is not supported, token_endpoint_auth_methods_supported is not advertised so only client_secret_basic is", method))
+		}
+	case contains(m.TokenEndpointAuthMethods, method):
+		checks = append(checks, pass(name, "[%s] is advertised by the provider", method))
+	default:
+		checks = append(checks, fail(name, "[%s] is not in token_endpoint_auth_methods_supported %v", method, m.TokenEndpointAuthMethods))
+	}
+
+	if assertion != nil && assertion.Err == nil && m.TokenEndpointAuthSigningAlgs != nil {
+		alg := assertion.JOSE.Algorithm
+		if contains(m.TokenEndpointAuthSigningAlgs, alg) {
+			checks = append(checks, pass("client_assertion alg", "[%s] is advertised by the provider", alg))
+		} else {
+			checks = append(checks, fail("client_assertion alg", "[%s] is not in token_endpoint_auth_signing_alg_values_supported %v", alg, m.TokenEndpointAuthSigningAlgs))
+		}
+	}
+
+	return checks
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestTestClientAuth(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	pemKey, jwkKey := writeSigningKeys(t)
+
+	tests := []struct {
+		clientAuth string
+		key        string
+		alg        string
+	}{
+		{authSecretBasic, "", ""},
+		{authSecretPost, "", ""},
+		{authSecretJWT, "", "HS256"},
+		{authPrivateKeyJWT, pemKey, "ES256"},
+		{authPrivateKeyJWT, jwkKey, "ES256"},
+		{authNone, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.clientAuth+" "+filepath.Base(tt.key), func(t *testing.T) {
+			cfg := testConfig(ts)
+			cfg.ClientAuth = tt.clientAuth
+			cfg.ClientAssertionKey = tt.key
+			if tt.clientAuth == authNone {
+				cfg.ClientSecret = ""
+			}
+
+			report := Test(cfg)
+			require.False(t, report.Failed(), "%v", report.Err)
+			require.NoError(t, report.Token.Err)
+			require.Equal(t, tt.clientAuth, report.Token.ClientAuth)
+			requireCheck(t, report.Token.Checks, "client_auth", true)
+
+			a := report.Token.ClientAssertion
+			if tt.alg == "" {
+				require.Nil(t, a)
+				return
+			}
+
+			require.NotNil(t, a)
+			require.NoError(t, a.Err)
+			require.Equal(t, tt.alg, a.JOSE.Algorithm)
+			require.Equal(t, "testing", a.Claims["iss"])
+			require.Equal(t, "testing", a.Claims["sub"])
+			require.Equal(t, []string{ts.URL + "/oauth2/token"}, audience(a.Claims["aud"]))
+			requireCheck(t, report.Token.Checks, "client_assertion alg", true)
+		})
+	}
+}
+
+func TestValidateClientAuth(t *testing.T) {
+	cfg := TestConfig{}
+	require.Equal(t, authNone, cfg.clientAuth())
+	require.Empty(t, cfg.validateClientAuth())
+
+	cfg.ClientSecret = "secret"
+	require.Equal(t, authSecretBasic, cfg.clientAuth())
+
+	cfg = TestConfig{ClientAuth: authPrivateKeyJWT}
+	require.Equal(t, []string{"clientAssertionKey is required for clientAuth [private_key_jwt]"}, cfg.validateClientAuth())
+
+	cfg = TestConfig{ClientAuth: authSecretJWT}
+	require.Equal(t, []string{"clientSecret is required for clientAuth [client_secret_jwt]"}, cfg.validateClientAuth())
+
+	cfg = TestConfig{ClientAuth: "client_secret"}
+	require.Len(t, cfg.validateClientAuth(), 1)
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	pemKey, jwkKey := writeSigningKeys(t)
+
+	k, err := loadPrivateKey("clientAssertionKey", pemKey)
+	require.NoError(t, err)
+	require.IsType(t, &ecdsa.PrivateKey{}, k.jwk.Key)
+
+	k, err = loadPrivateKey("clientAssertionKey", jwkKey)
+	require.NoError(t, err)
+	require.Equal(t, "test-client-key", k.jwk.KeyID)
+
+	public := k.jwk.Public()
+	data, err := json.Marshal(public)
+	require.NoError(t, err)
+	publicKey := filepath.Join(t.TempDir(), "public.json")
+	require.NoError(t, ioutil.WriteFile(publicKey, data, 0600))
+
+	_, err = loadPrivateKey("clientAssertionKey", publicKey)
+	require.Error(t, err)
+
+	certOnly, _ := writeClientCertificate(t)
+	_, err = loadPrivateKey("clientAssertionKey", certOnly)
+	require.Error(t, err)
+}
+
+func TestCheckClientAuth(t *testing.T) {
+	m := ProviderMetadata{}
+	requireCheck(t, checkClientAuth(authSecretBasic, nil, m), "client_auth", true)
+	requireCheck(t, checkClientAuth(authPrivateKeyJWT, nil, m), "client_auth", false)
+
+	none := checkClientAuth(authNone, nil, m)
+	requireCheck(t, none, "client_auth", true)
+	require.NotContains(t, none[0].Detail, "default")
+
+	m.TokenEndpointAuthMethods = []string{authSecretPost, authPrivateKeyJWT}
+	requireCheck(t, checkClientAuth(authPrivateKeyJWT, nil, m), "client_auth", true)
+	requireCheck(t, checkClientAuth(authSecretBasic, nil, m), "client_auth", false)
+
+	m.TokenEndpointAuthSigningAlgs = []string{"RS256"}
+	assertion := &TokenReport{JOSE: JOSEHeader{Algorithm: "ES256"}}
+	requireCheck(t, checkClientAuth(authPrivateKeyJWT, assertion, m), "client_assertion alg", false)
+}
+
+// writeSigningKeys saves the same P-256 key as PKCS#8 PEM and as a JWK and
+// returns the paths of both.
+func writeSigningKeys(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	pemPath := filepath.Join(dir, "client.pem")
+	require.NoError(t, ioutil.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+
+	data, err := json.Marshal(jose.JSONWebKey{Key: key, KeyID: "test-client-key"})
+	require.NoError(t, err)
+	jwkPath := filepath.Join(dir, "client.json")
+	require.NoError(t, ioutil.WriteFile(jwkPath, data, 0600))
+
+	return pemPath, jwkPath
+}
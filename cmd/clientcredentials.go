@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var clientCredentialsCmd = &cobra.Command{
+	Use:   "client-credentials",
+	Short: "Test JWT issued to a client using the client credentials grant",
+	Long: `Requests a token for the client itself, as a service would, and displays the JWT that was issued.
+Only the configured scopes are sent. Use extraParams for resource or audience to choose the API the token is for, it is checked against the aud of a JWT access token.`,
+	Run: clientCredentials,
+}
+
+var (
+	clientCredentialsConfigFile string
+	clientCredentialsTimeout    time.Duration
+)
+
+func init() {
+	f := clientCredentialsCmd.Flags()
+	f.StringVarP(&clientCredentialsConfigFile, "config", "c", "", "")
+	f.DurationVar(&clientCredentialsTimeout, "timeout", 0, "how long to wait for the token, overrides the config")
+	clientCredentialsCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(clientCredentialsCmd)
+}
+
+func clientCredentials(cmd *cobra.Command, args []string) {
+	runCommand(cmd, clientCredentialsConfigFile, clientCredentialsTimeout, ClientCredentialsContext)
+}
+
+// ClientCredentials requests a token with the client credentials grant
+// and reports the scopes and audience that were granted.
+func ClientCredentials(cfg TestConfig) *Report {
+	return ClientCredentialsContext(context.Background(), cfg)
+}
+
+// ClientCredentialsContext is ClientCredentials with a context to cancel
+// the request, it is also cancelled when cfg.Timeout passes.
+func ClientCredentialsContext(ctx context.Context, cfg TestConfig) *Report {
+	return run(ctx, cfg, (*flow).clientCredentials)
+}
+
+// clientCredentials requests a token for the client itself,
+// https://tools.ietf.org/html/rfc6749#section-4.4.2
+// Only the configured scopes are sent, openid is not added as there is
+// no user to issue an ID token for.
+func (f *flow) clientCredentials() {
+	f.oauth2.Scopes = f.cfg.Scopes
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(f.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(f.cfg.Scopes, " "))
+	}
+	f.cfg.addExtraParams(form)
+
+	f.handleTokenResponse(f.requestToken(form))
+}
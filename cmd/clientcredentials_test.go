@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCredentials(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.OpenURL = nil
+	cfg.ExtraParams = extra{"resource": {"https://api.test/"}}
+
+	report := ClientCredentials(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+	require.Nil(t, report.Authorization)
+	require.Nil(t, report.IDToken)
+
+	require.NotNil(t, report.Token)
+	require.NoError(t, report.Token.Err)
+	require.Empty(t, report.Token.RefreshToken)
+	requireCheck(t, report.Token.Checks, "scope", true)
+
+	at := report.AccessToken
+	require.NotNil(t, at)
+	require.Equal(t, "testing", at.Claims["sub"])
+	requireCheck(t, at.Checks, "client_id", true)
+	requireCheck(t, at.Checks, "audience", true)
+}
+
+func TestClientCredentialsPublicClient(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.ClientSecret = ""
+
+	report := ClientCredentials(cfg)
+	require.True(t, report.Failed())
+	require.Contains(t, report.Token.Err.Error(), "invalid_client")
+}
+
+func TestCheckRequestedAudience(t *testing.T) {
+	require.True(t, checkRequestedAudience([]string{"api"}, nil).OK)
+	require.True(t, checkRequestedAudience([]string{"api", "other"}, []string{"api"}).OK)
+	require.False(t, checkRequestedAudience([]string{"other"}, []string{"api"}).OK)
+}
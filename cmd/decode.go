@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// compactToken matches a JWS or JWE in compact serialization. Every JOSE
+// header is a JSON object so its base64url encoding starts with eyJ, which
+// picks tokens out of surrounding text such as an Authorization header,
+// a cookie or a token response.
+const compactToken = `eyJ[A-Za-z0-9_-]*(?:\.[A-Za-z0-9_-]*){2}(?:\.[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*)?`
+
+var (
+	tokenPattern       = regexp.MustCompile(compactToken)
+	nestedTokenPattern = regexp.MustCompile(`^` + compactToken + `$`)
+)
+
+// numericDateClaims are the claims shown as times by the decode command.
+var numericDateClaims = []string{"iat", "nbf", "exp", "auth_time", "updated_at"}
+
+var decodeCmd = &cobra.Command{
+	Use:   "decode [token]",
+	Short: "Decode a JWT, JWS or JWE",
+	Long: `Decodes the tokens found in the argument, --file or stdin and shows their header and claims. The signature is not verified.
+The input can be a bare token or text containing tokens, such as an Authorization: Bearer header, a cookie or a token response.
+Encrypted tokens are decrypted with --key and nested tokens are decoded in turn. Times such as iat and exp are shown in local time and UTC.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  decode,
+}
+
+var (
+	decodeFile string
+	decodeKey  string
+)
+
+func init() {
+	f := decodeCmd.Flags()
+	f.StringVarP(&decodeFile, "file", "f", "", "read the token from a file")
+	f.StringVar(&decodeKey, "key", "", "a PEM or JWK file with the private key to decrypt a JWE")
+	rootCmd.AddCommand(decodeCmd)
+}
+
+func decode(cmd *cobra.Command, args []string) {
+	input, err := readDecodeInput(args, decodeFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var key *privateKey
+	if decodeKey != "" {
+		key, err = loadPrivateKey("key", decodeKey)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	tokens := findTokens(input)
+	if len(tokens) == 0 {
+		fmt.Println("No JWT found in the input")
+		os.Exit(1)
+	}
+
+	failed := false
+	for i, raw := range tokens {
+		title := "Token"
+		if len(tokens) > 1 {
+			title = fmt.Sprintf("Token %d of %d", i+1, len(tokens))
+		}
+
+		d := decryptToken(raw, key)
+		printDecoded(os.Stdout, d, title, time.Now())
+		failed = failed || d.failed()
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// readDecodeInput returns the argument or the contents of the file, reading
+// stdin when neither is given or the argument is -.
+func readDecodeInput(args []string, file string) (string, error) {
+	switch {
+	case len(args) > 0 && file != "":
+		return "", errors.New("pass the token as an argument or with --file, not both")
+	case file != "":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("error reading token: %w", err)
+		}
+		return string(data), nil
+	case len(args) > 0:
+		return readToken(args[0])
+	default:
+		return readToken("-")
+	}
+}
+
+// findTokens returns the tokens in the input. Cookie values may be URL
+// encoded, so the input is unescaped when no token is found. When there is
+// still no match a bare input is returned as is, so decoding it explains
+// why it is not a token.
+func findTokens(input string) []string {
+	input = strings.TrimSpace(input)
+	if tokens := tokenPattern.FindAllString(input, -1); len(tokens) > 0 {
+		return tokens
+	}
+
+	if unescaped, err := url.QueryUnescape(input); err == nil && unescaped != input {
+		if tokens := tokenPattern.FindAllString(unescaped, -1); len(tokens) > 0 {
+			return tokens
+		}
+	}
+
+	if input != "" && !strings.ContainsAny(input, " \t\r\n") && strings.Contains(input, ".") {
+		return []string{input}
+	}
+	return nil
+}
+
+func printDecoded(w io.Writer, d *TokenReport, title string, now time.Time) {
+	kind := "JWS"
+	if d.Encrypted {
+		kind = "JWE"
+	}
+	fmt.Fprintf(w, "%s (%s)\n", title, kind)
+
+	if d.Header != nil {
+		fmt.Fprintln(w, "  Header:")
+		printJSON(w, d.Header, "    ")
+	}
+
+	switch {
+	case d.Claims != nil:
+		fmt.Fprintln(w, "  Claims:")
+		printJSON(w, d.Payload, "    ")
+		printClaimTimes(w, d.Claims, now)
+	case d.Payload != nil:
+		fmt.Fprintln(w, "  Payload:")
+		fmt.Fprintf(w, "    %s\n", d.Payload)
+	}
+
+	switch {
+	case d.Err != nil:
+	case d.Encrypted:
+		fmt.Fprintln(w, "  Decrypted with --key")
+	default:
+		fmt.Fprintln(w, "  Signature not verified")
+	}
+	printErr(w, d.Err)
+
+	if d.Nested != nil {
+		printDecoded(w, d.Nested, "Nested token", now)
+	}
+}
+
+// printClaimTimes shows the NumericDate claims in local time and UTC.
+func printClaimTimes(w io.Writer, claims map[string]interface{}, now time.Time) {
+	header := false
+	for _, name := range numericDateClaims {
+		t, ok := numericDate(claims[name])
+		if !ok {
+			continue
+		}
+
+		if !header {
+			fmt.Fprintln(w, "  Times:")
+			header = true
+		}
+
+		relative := fmt.Sprintf("in %s", humanDuration(t.Sub(now)))
+		if t.Before(now) {
+			relative = fmt.Sprintf("%s ago", humanDuration(now.Sub(t)))
+		}
+		fmt.Fprintf(w, "    %-10s %s  %s  %s\n", name+":", formatTime(t), t.UTC().Format(time.RFC3339), relative)
+	}
+}
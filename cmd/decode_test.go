@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func testJWS(header, payload string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString([]byte(payload)) + ".c2ln"
+}
+
+func TestFindTokens(t *testing.T) {
+	jws := testJWS(`{"alg":"RS256"}`, `{"sub":"someone@test"}`)
+	other := testJWS(`{"alg":"ES256"}`, `{"sub":"other@test"}`)
+
+	require.Equal(t, []string{jws}, findTokens(jws+"\n"))
+	require.Equal(t, []string{jws}, findTokens("Authorization: Bearer "+jws))
+	require.Equal(t, []string{jws}, findTokens("Cookie: theme=dark; session="+jws+"; lang=en"))
+	require.Equal(t, []string{jws}, findTokens("Set-Cookie: session="+jws+"; Path=/; HttpOnly"))
+	require.Equal(t, []string{jws, other}, findTokens(`{"id_token":"`+jws+`","access_token":"`+other+`"}`))
+	require.Equal(t, []string{jws}, findTokens("session=%65%79%4A"+jws[3:]))
+
+	require.Equal(t, []string{"not.a.jwt"}, findTokens("not.a.jwt"))
+	require.Empty(t, findTokens("Authorization: Bearer opaque"))
+}
+
+func TestDecryptToken(t *testing.T) {
+	d := decryptToken(testJWS(`{"alg":"RS256","typ":"JWT"}`, `{"sub":"someone@test","exp":1700000000}`), nil)
+	require.False(t, d.failed(), "%v", d.Err)
+	require.False(t, d.Encrypted)
+	require.Equal(t, "JWT", d.JOSE.Type)
+	require.Equal(t, "someone@test", d.Claims["sub"])
+
+	inner := testJWS(`{"alg":"RS256"}`, `{"sub":"someone@test"}`)
+	d = decryptToken(testJWS(`{"alg":"RS256","cty":"JWT"}`, inner), nil)
+	require.False(t, d.failed(), "%v", d.Err)
+	require.Nil(t, d.Claims)
+	require.NotNil(t, d.Nested)
+	require.Equal(t, "someone@test", d.Nested.Claims["sub"])
+
+	// some encoders leave the base64 padding in
+	enc := base64.URLEncoding
+	d = decodeToken(enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString([]byte(`{"sub":"a"}`)) + ".c2ln")
+	require.False(t, d.failed(), "%v", d.Err)
+	require.Equal(t, "a", d.Claims["sub"])
+
+	d = decryptToken(testJWS(`{"alg":"RS256"}`, `not json`), nil)
+	require.True(t, d.failed())
+	require.Equal(t, "not json", string(d.Payload))
+
+	d = decryptToken("not.a.jwt.at.all.really", nil)
+	require.Error(t, d.Err)
+}
+
+func TestDecryptTokenJWE(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	enc, err := jose.NewEncrypter(jose.A128GCM, jose.Recipient{Algorithm: jose.RSA_OAEP, Key: &key.PublicKey}, new(jose.EncrypterOptions).WithContentType("JWT"))
+	require.NoError(t, err)
+	jwe, err := enc.Encrypt([]byte(testJWS(`{"alg":"RS256"}`, `{"sub":"someone@test"}`)))
+	require.NoError(t, err)
+	raw, err := jwe.CompactSerialize()
+	require.NoError(t, err)
+
+	d := decryptToken(raw, nil)
+	require.True(t, d.Encrypted)
+	require.Error(t, d.Err)
+	require.Contains(t, string(d.Header), `"RSA-OAEP"`)
+
+	d = decryptToken(raw, &privateKey{jwk: jose.JSONWebKey{Key: key}})
+	require.False(t, d.failed(), "%v", d.Err)
+	require.NotNil(t, d.Nested)
+	require.Equal(t, "someone@test", d.Nested.Claims["sub"])
+
+	var buf bytes.Buffer
+	printDecoded(&buf, d, "Token", time.Now())
+	require.Contains(t, buf.String(), "Token (JWE)")
+	require.Contains(t, buf.String(), "Nested token (JWS)")
+	require.Contains(t, buf.String(), `"sub": "someone@test"`)
+
+	buf.Reset()
+	printToken(&buf, d)
+	require.Contains(t, buf.String(), `"RSA-OAEP"`)
+	require.Contains(t, buf.String(), "Nested token:")
+	require.Contains(t, buf.String(), `"sub": "someone@test"`)
+}
+
+func TestPrintClaimTimes(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var buf bytes.Buffer
+	printClaimTimes(&buf, map[string]interface{}{
+		"iat": float64(1700000000 - 60),
+		"exp": float64(1700000000 + 3600),
+		"sub": "someone@test",
+	}, now)
+
+	out := buf.String()
+	require.Contains(t, out, "2023-11-14T22:12:20Z  1m0s ago")
+	require.Contains(t, out, "2023-11-14T23:13:20Z  in 1h0m0s")
+	require.NotContains(t, out, "sub")
+}
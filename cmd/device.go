@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+)
+
+const deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// minDeviceInterval is the default polling interval, a shorter interval
+// from the provider is not used so the token endpoint is not flooded,
+// https://tools.ietf.org/html/rfc8628#section-3.2
+const minDeviceInterval = 5 * time.Second
+
+// DeviceReport holds the response from the device authorization endpoint
+// and how polling the token endpoint went,
+// https://tools.ietf.org/html/rfc8628#section-3.2
+type DeviceReport struct {
+	EndpointReport
+
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresIn               time.Duration
+
+	// Interval is the time between polls, including any increases
+	// asked for by slow_down responses.
+	Interval time.Duration
+
+	// Polls is the number of token requests made, SlowDowns how many
+	// of them asked the client to poll less often.
+	Polls     int
+	SlowDowns int
+
+	Checks []Check
+}
+
+var deviceCmd = &cobra.Command{
+	Use:   "device",
+	Short: "Test JWT issued by OIDC server using the device flow",
+	Long: `Handles a device authorization grant, for machines without a browser, and displays the JWT that was issued.
+The user code and verification URI are shown, to be entered on another device, and the token endpoint is polled until the request is approved.
+Without --timeout polling stops when the device code expires.`,
+	Run: device,
+}
+
+var (
+	deviceConfigFile string
+	deviceTimeout    time.Duration
+	deviceQR         bool
+)
+
+func init() {
+	f := deviceCmd.Flags()
+	f.StringVarP(&deviceConfigFile, "config", "c", "", "")
+	f.DurationVar(&deviceTimeout, "timeout", 0, "how long to wait for the login to complete, by default until the device code expires")
+	f.BoolVar(&deviceQR, "qr", false, "show the verification URI as a QR code")
+	deviceCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(deviceCmd)
+}
+
+func device(cmd *cobra.Command, args []string) {
+	runCommand(cmd, deviceConfigFile, deviceTimeout, func(ctx context.Context, cfg TestConfig) *Report {
+		// The device code's expires_in bounds the polling.
+		if !cmd.Flags().Changed("timeout") {
+			cfg.Timeout = 0
+		}
+		cfg.DevicePrompt = func(d *DeviceReport) {
+			printDevicePrompt(os.Stdout, d, deviceQR)
+		}
+		return DeviceContext(ctx, cfg)
+	})
+}
+
+// Device runs a device authorization grant against the configured provider,
+// calling cfg.DevicePrompt with the user code while the token endpoint is
+// polled.
+func Device(cfg TestConfig) *Report {
+	return DeviceContext(context.Background(), cfg)
+}
+
+// DeviceContext is Device with a context to cancel the flow, the flow is
+// also cancelled when cfg.Timeout passes.
+func DeviceContext(ctx context.Context, cfg TestConfig) *Report {
+	return run(ctx, cfg, (*flow).deviceLogin)
+}
+
+// deviceLogin requests a device code and polls the token endpoint until
+// the user approves or denies the request on another device.
+func (f *flow) deviceLogin() {
+	m := f.report.Provider.Metadata
+	if m.DeviceAuthorizationEndpoint == "" {
+		f.report.Err = errors.New("provider did not advertise a device_authorization_endpoint")
+		return
+	}
+
+	form := url.Values{"scope": {strings.Join(f.oauth2.Scopes, " ")}}
+	f.cfg.addExtraParams(form)
+
+	endpoint := m.endpoint("device_authorization_endpoint", m.DeviceAuthorizationEndpoint, f.certThumbprint != "")
+	d := &DeviceReport{EndpointReport: postForm(f.ctx, f.client, &f.cfg, endpoint, form, "application/json")}
+	f.report.Device = d
+	d.Checks = append(d.Checks, checkClientAuth(d.ClientAuth, d.ClientAssertion, m)...)
+	if d.Err != nil {
+		return
+	}
+
+	d.Err = d.decode()
+	if d.Err != nil {
+		return
+	}
+
+	d.Checks = append(d.Checks, checkVerificationURIComplete(d.VerificationURIComplete, d.UserCode))
+
+	if f.cfg.DevicePrompt != nil {
+		f.cfg.DevicePrompt(d)
+	}
+
+	f.pollToken(d)
+}
+
+// decode reads the device authorization response. The interval defaults
+// to 5 seconds, which is also the shortest interval polled at.
+func (d *DeviceReport) decode() error {
+	err := d.decodeFields()
+	if err != nil {
+		return err
+	}
+
+	d.DeviceCode, _ = d.Fields["device_code"].(string)
+	d.UserCode, _ = d.Fields["user_code"].(string)
+	d.VerificationURI, _ = d.Fields["verification_uri"].(string)
+	d.VerificationURIComplete, _ = d.Fields["verification_uri_complete"].(string)
+
+	var missing []string
+	for _, name := range []string{"device_code", "user_code", "verification_uri", "expires_in"} {
+		if _, ok := d.Fields[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if missing != nil {
+		return fmt.Errorf("device authorization response is missing %v", missing)
+	}
+
+	d.ExpiresIn, err = expiresIn(d.Fields["expires_in"])
+	if err != nil {
+		return err
+	}
+
+	d.Interval = minDeviceInterval
+	if raw, ok := d.Fields["interval"]; ok {
+		d.Interval, err = expiresIn(raw)
+		if err != nil {
+			return fmt.Errorf("interval is not a number: %v", raw)
+		}
+		if d.Interval < minDeviceInterval {
+			d.Interval = minDeviceInterval
+		}
+	}
+
+	return nil
+}
+
+// pollToken polls the token endpoint every interval until the device code
+// is approved, denied or expires,
+// https://tools.ietf.org/html/rfc8628#section-3.4
+func (f *flow) pollToken(d *DeviceReport) {
+	expired := time.NewTimer(d.ExpiresIn)
+	defer expired.Stop()
+
+	for {
+		select {
+		case <-time.After(d.Interval):
+		case <-expired.C:
+			d.Err = fmt.Errorf("device code expired after %s waiting for the user to approve it", d.ExpiresIn)
+			return
+		case <-f.ctx.Done():
+			f.report.Err = f.cancelled("the login to complete")
+			return
+		}
+
+		d.Polls++
+		t := f.requestToken(url.Values{
+			"grant_type":  {deviceCodeGrant},
+			"device_code": {d.DeviceCode},
+		})
+
+		var oauthErr *OAuthError
+		if errors.As(t.Err, &oauthErr) {
+			switch oauthErr.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				// https://tools.ietf.org/html/rfc8628#section-3.5
+				d.SlowDowns++
+				d.Interval += 5 * time.Second
+				continue
+			}
+		}
+
+		f.handleTokenResponse(t)
+		return
+	}
+}
+
+// checkVerificationURIComplete reports if the provider returned a URI that
+// includes the user code, so the user does not need to type it. The URI is
+// optional, https://tools.ietf.org/html/rfc8628#section-3.2
+func checkVerificationURIComplete(uri, userCode string) Check {
+	const name = "verification_uri_complete"
+	switch {
+	case uri == "":
+		return pass(name, "not returned, the user must enter the user code")
+	case !strings.Contains(uri, url.QueryEscape(userCode)) && !strings.Contains(uri, userCode):
+		return fail(name, "[%s] does not contain the user code [%s]", uri, userCode)
+	default:
+		return pass(name, "[%s]", uri)
+	}
+}
+
+// printDevicePrompt tells the user where to approve the request, with the
+// verification URI as a QR code when qr is set.
+func printDevicePrompt(w io.Writer, d *DeviceReport, qr bool) {
+	fmt.Fprintln(w, "To sign in, use a browser on another device to visit")
+	fmt.Fprintf(w, "  %s\n", d.VerificationURI)
+	fmt.Fprintf(w, "and enter the code %s\n", d.UserCode)
+
+	uri := d.VerificationURI
+	if d.VerificationURIComplete != "" {
+		uri = d.VerificationURIComplete
+		fmt.Fprintln(w, "or visit")
+		fmt.Fprintf(w, "  %s\n", uri)
+	}
+
+	if qr {
+		code, err := qrcode.New(uri, qrcode.Low)
+		if err != nil {
+			printErr(w, err)
+		} else {
+			fmt.Fprint(w, code.ToSmallString(false))
+		}
+	}
+
+	fmt.Fprintf(w, "Waiting for approval, the code expires in %s\n", d.ExpiresIn)
+}
+
+func printDevice(w io.Writer, d *DeviceReport, showSecrets bool) {
+	printEndpoint(w, &d.EndpointReport, showSecrets)
+	if d.UserCode != "" {
+		fmt.Fprintf(w, "  User code: %s\n", d.UserCode)
+		fmt.Fprintf(w, "  Polled %d times, every %s, slow_down %d times\n", d.Polls, d.Interval, d.SlowDowns)
+	}
+	printChecks(w, d.Checks)
+	printErr(w, d.Err)
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevice(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.OpenURL = nil
+
+	// Approve after the first poll so the pending response is handled.
+	approved := make(chan error, 1)
+	cfg.DevicePrompt = func(d *DeviceReport) {
+		time.AfterFunc(testmock.DeviceInterval+testmock.DeviceInterval/2, func() {
+			res, err := http.Get(d.VerificationURIComplete)
+			if err == nil {
+				res.Body.Close()
+			}
+			approved <- err
+		})
+	}
+
+	report := Device(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+	require.NoError(t, <-approved)
+
+	d := report.Device
+	require.NotNil(t, d)
+	require.Equal(t, ts.URL+"/oauth2/device/auth", d.URL)
+	require.NotEmpty(t, d.UserCode)
+	require.Equal(t, testmock.DeviceInterval, d.Interval)
+	require.Equal(t, 2, d.Polls)
+	requireCheck(t, d.Checks, "verification_uri_complete", true)
+
+	require.NotNil(t, report.Token)
+	require.NoError(t, report.Token.Err)
+	require.NotNil(t, report.IDToken)
+	require.Equal(t, "someone@test", report.IDToken.Claims["sub"])
+	requireCheck(t, report.IDToken.Checks, "aud", true)
+
+	var buf bytes.Buffer
+	printDevicePrompt(&buf, d, true)
+	require.Contains(t, buf.String(), d.UserCode)
+}
+
+func TestDeviceTimeout(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.Timeout = testmock.DeviceInterval / 2
+
+	report := Device(cfg)
+	require.True(t, report.Failed())
+	require.Contains(t, report.Err.Error(), "timed out")
+	require.Nil(t, report.Token)
+}
+
+func TestCheckVerificationURIComplete(t *testing.T) {
+	require.True(t, checkVerificationURIComplete("https://idp.test/device?user_code=ABCD-EFGH", "ABCD-EFGH").OK)
+	require.False(t, checkVerificationURIComplete("https://idp.test/device", "ABCD-EFGH").OK)
+	require.True(t, checkVerificationURIComplete("", "ABCD-EFGH").OK)
+}
+
+func TestDeviceInterval(t *testing.T) {
+	const response = `{"device_code":"d","user_code":"u","verification_uri":"https://idp.test/device","expires_in":600%s}`
+
+	for interval, want := range map[string]time.Duration{
+		"":               minDeviceInterval,
+		`,"interval":0`:  minDeviceInterval,
+		`,"interval":-1`: minDeviceInterval,
+		`,"interval":10`: 10 * time.Second,
+	} {
+		d := &DeviceReport{EndpointReport: EndpointReport{Raw: []byte(fmt.Sprintf(response, interval))}}
+		require.NoError(t, d.decode())
+		require.Equal(t, want, d.Interval, interval)
+	}
+}
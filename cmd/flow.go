@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+// flow holds the state shared by the steps of a single test run.
+type flow struct {
+	ctx    context.Context
+	cfg    TestConfig
+	client *http.Client
+	report *Report
+	oauth2 *oauth2.Config
+
+	pkce  *PKCEReport
+	state string
+	nonce string
+
+	// accessToken is the access token issued by the flow, used to
+	// call the UserInfo endpoint. idToken is the latest ID token, sent
+	// as the id_token_hint when logging out.
+	accessToken string
+	idToken     string
+
+	// certThumbprint is the x5t#S256 of the client certificate used for
+	// mutual TLS, empty when no certificate is configured.
+	certThumbprint string
+
+	// mu guards the report while the local server is handling requests,
+	// done is closed once the provider's response has been handled and
+	// logoutDone once the provider redirects back after logging out.
+	mu         sync.Mutex
+	done       chan struct{}
+	logoutDone chan struct{}
+
+	// notificationsDone is closed once notificationLimit logout
+	// notifications have been received, it is nil unless only listening
+	// for notifications.
+	notificationsDone chan struct{}
+	notificationLimit int
+}
+
+func (f *flow) newAuthorization() *AuthorizationReport {
+	return &AuthorizationReport{PKCE: f.pkce, State: f.state, Nonce: f.nonce}
+}
+
+// handleResponse processes the parameters the provider sent back to the
+// callback. The mode is how they were received, query, fragment or form_post.
+func (f *flow) handleResponse(callback, mode string, response url.Values) {
+	a := f.report.Authorization
+	if a == nil {
+		a = f.newAuthorization()
+		f.report.Authorization = a
+	}
+	a.Callback = callback
+	a.Response = response
+
+	// https://openid.net/specs/oauth-v2-jarm.html#section-4.4
+	if raw := response.Get("response"); raw != "" {
+		mode += ".jwt"
+		a.JARM = f.decodeJARM(raw)
+		response = jarmParams(a.JARM.Claims)
+	}
+
+	a.ResponseMode = mode
+	a.Checks = append(a.Checks, checkResponseMode(f.cfg.responseMode(), mode, f.report.Provider.Metadata.ResponseModesSupported))
+
+	// A mismatched state is reported and the tokens in the response are
+	// still decoded so they can be inspected, but the code is not redeemed.
+	state := checkState(f.state, response.Get("state"))
+	a.Checks = append(a.Checks, state)
+
+	// https://tools.ietf.org/html/rfc6749#section-4.1.2.1
+	if code := response.Get("error"); code != "" {
+		oauthErr := &OAuthError{
+			Code:        code,
+			Description: response.Get("error_description"),
+			URI:         response.Get("error_uri"),
+		}
+		a.Err = oauthErr
+		a.Hints = hints(oauthErr)
+		return
+	}
+
+	a.Checks = append(a.Checks, checkResponseType(f.cfg.responseType(), response))
+
+	code := response.Get("code")
+	accessToken := response.Get("access_token")
+	f.accessToken = accessToken
+
+	var idToken *TokenReport
+	if raw := response.Get("id_token"); raw != "" {
+		f.idToken = raw
+		idToken = f.decodeIDToken(raw, f.nonce)
+		if idToken.Claims != nil {
+			alg := idToken.JOSE.Algorithm
+			idToken.Checks = append(idToken.Checks, checkHash("c_hash", idToken.Claims, alg, code)...)
+			idToken.Checks = append(idToken.Checks, checkHash("at_hash", idToken.Claims, alg, accessToken)...)
+		}
+	}
+
+	if isJWT(accessToken) {
+		f.report.AccessToken = f.decodeAccessToken(accessToken)
+	}
+
+	if code == "" {
+		f.report.IDToken = idToken
+		return
+	}
+
+	// In a hybrid flow the ID token from the token endpoint is the one
+	// reported, the one from the authorization endpoint is kept with
+	// the authorization response.
+	a.IDToken = idToken
+
+	// Redeeming the code would complete a login the client may not have
+	// started, https://tools.ietf.org/html/rfc6749#section-10.12
+	if !state.OK {
+		a.Err = errors.New("the code was not exchanged as the callback state does not match")
+		return
+	}
+	f.exchangeCode(code)
+}
+
+// exchangeCode redeems the authorization code at the token endpoint and
+// decodes the tokens in the response.
+func (f *flow) exchangeCode(code string) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {f.oauth2.RedirectURL},
+	}
+	if f.pkce != nil {
+		form.Set("code_verifier", f.pkce.Verifier)
+	}
+	f.cfg.addExtraParams(form)
+
+	f.handleTokenResponse(f.requestToken(form))
+}
+
+// requestToken posts form to the token endpoint, or its mTLS alias when
+// a client certificate is configured.
+func (f *flow) requestToken(form url.Values) *TokenResponseReport {
+	tokenURL := f.report.Provider.Metadata.endpoint("token_endpoint", f.oauth2.Endpoint.TokenURL, f.certThumbprint != "")
+	return requestToken(f.ctx, f.client, &f.cfg, tokenURL, form)
+}
+
+// handleTokenResponse records the token endpoint's response and decodes
+// and validates the tokens it contains.
+func (f *flow) handleTokenResponse(t *TokenResponseReport) {
+	f.report.Token = t
+	f.report.IDToken, f.report.AccessToken = f.decodeTokens(t, f.nonce)
+	if t.Err == nil {
+		f.accessToken = t.AccessToken
+		if t.IDToken != "" {
+			f.idToken = t.IDToken
+		}
+	}
+
+	// An ID token is only issued when the openid scope was requested,
+	// which the client credentials grant does not do by default.
+	if t.Err == nil && t.IDToken == "" && contains(f.oauth2.Scopes, oidc.ScopeOpenID) {
+		t.Checks = append(t.Checks, fail("id_token", "token response did not contain an id_token"))
+	}
+}
+
+// decodeTokens validates the token response and decodes the ID token and,
+// when it is a JWT, the access token. Either is nil when not returned. The
+// ID token's nonce is checked when nonce is not empty.
+func (f *flow) decodeTokens(t *TokenResponseReport, nonce string) (idToken, accessToken *TokenReport) {
+	t.Checks = append(t.Checks, checkClientAuth(t.ClientAuth, t.ClientAssertion, f.report.Provider.Metadata)...)
+	if t.Err != nil {
+		return nil, nil
+	}
+
+	t.Checks = append(t.Checks, checkScope(f.oauth2.Scopes, t.Fields))
+
+	if t.IDToken != "" {
+		idToken = f.decodeIDToken(t.IDToken, nonce)
+		if idToken.Claims != nil {
+			idToken.Checks = append(idToken.Checks, checkHash("at_hash", idToken.Claims, idToken.JOSE.Algorithm, t.AccessToken)...)
+		}
+	}
+
+	if isJWT(t.AccessToken) {
+		accessToken = f.decodeAccessToken(t.AccessToken)
+	}
+
+	return idToken, accessToken
+}
+
+func (f *flow) decodeIDToken(raw, nonce string) *TokenReport {
+	t := f.decodeVerified(raw, f.report.Provider.Metadata.IDTokenSigningAlgs)
+	if t.Claims == nil {
+		return t
+	}
+
+	// There is no nonce when the ID token is not the result of an
+	// authorization request, such as the device flow or a refresh.
+	if nonce != "" {
+		t.Checks = append(t.Checks, checkNonce(nonce, t.Claims))
+	}
+	t.Checks = append(t.Checks, checkClaims(t.Claims, f.expectations())...)
+	return t
+}
+
+// decodeAccessToken decodes an access token issued as a JWT, checking it
+// against the profile in https://tools.ietf.org/html/rfc9068
+func (f *flow) decodeAccessToken(raw string) *TokenReport {
+	t := f.decodeVerified(raw, nil)
+	if t.Claims == nil {
+		return t
+	}
+
+	t.Checks = append(t.Checks, checkAccessTokenClaims(t.JOSE, t.Claims, f.expectations())...)
+	t.Checks = append(t.Checks, checkRequestedAudience(audience(t.Claims["aud"]), f.cfg.requestedAudience()))
+	if f.certThumbprint != "" {
+		t.Checks = append(t.Checks, checkCertificateBinding(t.Claims, f.certThumbprint))
+	}
+	return t
+}
+
+// decodeVerified decodes the token and verifies its signature. The claims
+// are removed when the signature can not be verified, unless the config
+// asks for unverified tokens to be shown.
+func (f *flow) decodeVerified(raw string, supportedAlgs []string) *TokenReport {
+	t := decodeToken(raw)
+	if t.Err != nil {
+		return t
+	}
+
+	p := f.report.Provider
+	t.Checks = append(t.Checks, checkHeader(t.JOSE, supportedAlgs, p.JWKS)...)
+	t.Signature = verifySignature(raw, p.JWKS, p.JWKSErr)
+	if !t.Signature.Verified && !f.cfg.ShowUnverified {
+		t.Payload = nil
+		t.Claims = nil
+		t.Err = errors.New("claims hidden as the signature was not verified, set showUnverified to display them")
+	}
+
+	return t
+}
+
+// decodeJARM decodes a JWT secured authorization response,
+// https://openid.net/specs/oauth-v2-jarm.html#section-4.4
+func (f *flow) decodeJARM(raw string) *TokenReport {
+	t := f.decodeVerified(raw, f.report.Provider.Metadata.AuthorizationSigningAlgs)
+	if t.Claims == nil {
+		return t
+	}
+
+	e := f.expectations()
+	t.Checks = append(t.Checks,
+		checkIssuer(t.Claims["iss"], e.Issuer),
+		checkAudience(audience(t.Claims["aud"]), e.ClientID),
+		checkExpiry(t.Claims["exp"], e.Now, e.ClockSkew),
+	)
+	return t
+}
+
+// jarmParams extracts the authorization response parameters from the JARM
+// claims, leaving out the claims that secure the response.
+func jarmParams(claims map[string]interface{}) url.Values {
+	params := url.Values{}
+	for k, v := range claims {
+		switch k {
+		case "iss", "aud", "exp":
+			continue
+		}
+		if s, ok := v.(string); ok {
+			params.Set(k, s)
+		}
+	}
+	return params
+}
+
+// cancelled describes why the flow's context finished while waiting for
+// something to happen, such as "the login to complete".
+func (f *flow) cancelled(waitingFor string) error {
+	if f.ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s waiting for %s", f.cfg.Timeout, waitingFor)
+	}
+	return fmt.Errorf("cancelled waiting for %s: %w", waitingFor, f.ctx.Err())
+}
+
+func (f *flow) expectations() claimExpectations {
+	return claimExpectations{
+		Issuer:    f.cfg.IssuerURL,
+		ClientID:  f.cfg.ClientID,
+		ClockSkew: f.cfg.ClockSkew,
+		Now:       time.Now(),
+	}
+}
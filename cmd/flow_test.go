@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestResponseTypes(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	for _, responseType := range []string{"id_token", "code id_token", "code id_token token"} {
+		t.Run(responseType, func(t *testing.T) {
+			cfg := testConfig(ts)
+			cfg.ResponseType = responseType
+
+			report := Test(cfg)
+			requireResponse(t, report, responseType, "fragment")
+		})
+	}
+}
+
+func TestTestResponseModes(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	tests := []struct {
+		responseType string
+		responseMode string
+		mode         string
+	}{
+		{"code", "form_post", "form_post"},
+		{"code id_token", "form_post", "form_post"},
+		{"code", "jwt", "query.jwt"},
+		{"code id_token", "form_post.jwt", "form_post.jwt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.responseType+" "+tt.responseMode, func(t *testing.T) {
+			cfg := testConfig(ts)
+			cfg.ResponseType = tt.responseType
+			cfg.ResponseMode = tt.responseMode
+
+			report := Test(cfg)
+			requireResponse(t, report, tt.responseType, tt.mode)
+		})
+	}
+}
+
+func TestPrintAuthorizationResponse(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.ResponseType = "code id_token token"
+
+	report := Test(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+
+	response := report.Authorization.Response
+	secrets := []string{response.Get("code"), response.Get("id_token"), response.Get("access_token")}
+	for _, s := range secrets {
+		require.NotEmpty(t, s)
+	}
+
+	var buf bytes.Buffer
+	printReport(&buf, report, false)
+	for _, s := range secrets {
+		require.NotContains(t, buf.String(), s)
+	}
+
+	buf.Reset()
+	printReport(&buf, report, true)
+	require.Contains(t, buf.String(), response.Get("code"))
+}
+
+// requireResponse checks the login succeeded with the response type and
+// the response was received using mode.
+func requireResponse(t *testing.T, report *Report, responseType, mode string) {
+	t.Helper()
+	require.False(t, report.Failed(), "%v", report.Err)
+
+	a := report.Authorization
+	require.Equal(t, mode, a.ResponseMode)
+	requireCheck(t, a.Checks, "state", true)
+	requireCheck(t, a.Checks, "response_type", true)
+	requireCheck(t, a.Checks, "response_mode", true)
+
+	require.NotNil(t, report.IDToken)
+	require.Equal(t, "someone@test", report.IDToken.Claims["sub"])
+	requireCheck(t, report.IDToken.Checks, "nonce", true)
+
+	types := strings.Fields(responseType)
+	if contains(types, "code") && contains(types, "id_token") {
+		require.NotNil(t, a.IDToken)
+		requireCheck(t, a.IDToken.Checks, "c_hash", true)
+	}
+	if contains(types, "code") && contains(types, "token") {
+		requireCheck(t, a.IDToken.Checks, "at_hash", true)
+	}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// JOSEHeader holds the protected header fields of a JWT that are useful
+// when debugging signature and key rotation issues.
+type JOSEHeader struct {
+	Algorithm   string   `json:"alg"`
+	KeyID       string   `json:"kid,omitempty"`
+	Type        string   `json:"typ,omitempty"`
+	ContentType string   `json:"cty,omitempty"`
+	X5T         string   `json:"x5t,omitempty"`
+	X5TS256     string   `json:"x5t#S256,omitempty"`
+	Critical    []string `json:"crit,omitempty"`
+}
+
+// checkHeader flags header values that will cause a relying party to
+// reject the token, or that should never be accepted.
+func checkHeader(h JOSEHeader, supported []string, keys *jose.JSONWebKeySet) []Check {
+	return []Check{
+		checkAlgorithm(h.Algorithm, supported, keys),
+		checkKeyID(h.KeyID, keys),
+		checkCritical(h.Critical),
+	}
+}
+
+func checkAlgorithm(alg string, supported []string, keys *jose.JSONWebKeySet) Check {
+	const name = "alg"
+	switch {
+	case alg == "":
+		return fail(name, "header does not contain an alg")
+	case strings.EqualFold(alg, "none"):
+		return fail(name, "token is unsigned, alg [%s] must never be accepted", alg)
+	case strings.HasPrefix(alg, "HS") && !hasSymmetricKeys(supported, keys):
+		return fail(name, "[%s] is a symmetric alg but the provider publishes asymmetric keys, the token was signed with a shared secret", alg)
+	case supported != nil && !contains(supported, alg):
+		return fail(name, "[%s] is not in the provider's supported algs %v", alg, supported)
+	default:
+		return pass(name, "[%s]", alg)
+	}
+}
+
+func checkKeyID(kid string, keys *jose.JSONWebKeySet) Check {
+	const name = "kid"
+	switch {
+	case keys == nil:
+		return pass(name, "[%s] JWKS not available to check", kid)
+	case kid == "" && len(keys.Keys) > 1:
+		return pass(name, "not present, JWKS has %d keys to try", len(keys.Keys))
+	case kid == "":
+		return pass(name, "not present")
+	case len(keys.Key(kid)) == 0:
+		return fail(name, "[%s] is not in the JWKS, the provider may have rotated its keys", kid)
+	default:
+		return pass(name, "[%s] found in JWKS", kid)
+	}
+}
+
+func checkCritical(crit []string) Check {
+	const name = "crit"
+	if len(crit) == 0 {
+		return pass(name, "not present")
+	}
+	return fail(name, "token requires understanding of header params %v", crit)
+}
+
+// hasSymmetricKeys reports if the provider could legitimately issue tokens
+// signed with an HMAC alg, either advertising it or publishing oct keys.
+func hasSymmetricKeys(supported []string, keys *jose.JSONWebKeySet) bool {
+	for _, alg := range supported {
+		if strings.HasPrefix(alg, "HS") {
+			return true
+		}
+	}
+
+	if keys != nil {
+		for _, k := range keys.Keys {
+			if _, ok := k.Key.([]byte); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"testing"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestCheckHeader(t *testing.T) {
+	key := newTestKey(t, "a").Public()
+	keys := &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key}}
+	supported := []string{"RS256"}
+
+	tests := []struct {
+		name   string
+		header JOSEHeader
+		check  string
+		ok     bool
+	}{
+		{"valid", JOSEHeader{Algorithm: "RS256", KeyID: "a"}, "alg", true},
+		{"alg none", JOSEHeader{Algorithm: "none"}, "alg", false},
+		{"hmac on asymmetric provider", JOSEHeader{Algorithm: "HS256", KeyID: "a"}, "alg", false},
+		{"unsupported alg", JOSEHeader{Algorithm: "ES256", KeyID: "a"}, "alg", false},
+		{"unknown kid", JOSEHeader{Algorithm: "RS256", KeyID: "b"}, "kid", false},
+		{"crit", JOSEHeader{Algorithm: "RS256", KeyID: "a", Critical: []string{"exp"}}, "crit", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireCheck(t, checkHeader(tt.header, supported, keys), tt.check, tt.ok)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// errorHints are remediation hints for the error codes from
+// https://tools.ietf.org/html/rfc6749#section-4.1.2.1 and
+// https://openid.net/specs/openid-connect-core-1_0.html#AuthError
+var errorHints = map[string]string{
+	"invalid_request":            "a parameter is missing or malformed, check the authorization URL params and extraParams",
+	"unauthorized_client":        "the client is not allowed to use this grant or response type, check the client registration",
+	"access_denied":              "the user or the provider's policy denied the request",
+	"unsupported_response_type":  "the provider does not support the responseType, check response_types_supported in discovery",
+	"invalid_scope":              "a requested scope is unknown or not allowed for this client, check scopes against scopes_supported",
+	"server_error":               "the provider hit an internal error, check the provider's logs",
+	"temporarily_unavailable":    "the provider is overloaded or down for maintenance, try again later",
+	"interaction_required":       "prompt=none was sent but the user needs to interact with the provider",
+	"login_required":             "prompt=none was sent but the user does not have a session with the provider",
+	"account_selection_required": "the user has multiple sessions and must choose one, remove prompt=none",
+	"consent_required":           "the user has not consented to the requested scopes, remove prompt=none or pre-authorize the client",
+	"invalid_request_uri":        "the request_uri could not be retrieved or is invalid",
+	"invalid_request_object":     "the request object is invalid",
+	"request_not_supported":      "the provider does not support the request parameter",
+	"request_uri_not_supported":  "the provider does not support the request_uri parameter",
+	"registration_not_supported": "the provider does not support the registration parameter",
+}
+
+// aadErrorHints are hints for common Azure AD errors, these are reported in
+// error_description with an AADSTS prefix.
+var aadErrorHints = map[string]string{
+	"50011":   "the redirect URI does not match one registered for the application, check the clientPort and callback path",
+	"50020":   "the user's account is not from a tenant that can sign in to the application",
+	"50076":   "multi-factor authentication is required",
+	"50079":   "the user must enroll for multi-factor authentication",
+	"50105":   "the user is not assigned to a role for the application",
+	"50126":   "the username or password is incorrect",
+	"54005":   "the authorization code has already been redeemed",
+	"65001":   "the user or an administrator has not consented to the application",
+	"70011":   "a requested scope is invalid, check scopes",
+	"90014":   "a required field is missing from the request",
+	"700016":  "the application was not found in the tenant, check the clientID and issuerURL tenant",
+	"700051":  "the response type is not enabled for the application, enable implicit grant for id_token or token",
+	"700054":  "response_type id_token is not enabled for the application",
+	"7000215": "an invalid client secret was provided, check clientSecret",
+	"7000218": "the request needs a client_assertion or client_secret, the client is not registered as public",
+}
+
+var aadErrorCode = regexp.MustCompile(`AADSTS(\d+)`)
+
+// hints returns the remediation hints for the error.
+func hints(e *OAuthError) []string {
+	var h []string
+
+	if hint, ok := errorHints[e.Code]; ok {
+		h = append(h, hint)
+	}
+
+	for _, m := range aadErrorCode.FindAllStringSubmatch(e.Description, -1) {
+		if hint, ok := aadErrorHints[m[1]]; ok {
+			h = append(h, fmt.Sprintf("AADSTS%s: %s", m[1], hint))
+		}
+	}
+
+	return h
+}
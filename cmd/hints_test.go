@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestErrorResponse(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.ExtraParams = extra{"prompt": {"none"}}
+
+	report := Test(cfg)
+	require.True(t, report.Failed())
+
+	var oauthErr *OAuthError
+	require.True(t, errors.As(report.Authorization.Err, &oauthErr))
+	require.Equal(t, "login_required", oauthErr.Code)
+	require.NotEmpty(t, report.Authorization.Hints)
+	require.Nil(t, report.Token)
+}
+
+func TestHints(t *testing.T) {
+	h := hints(&OAuthError{Code: "invalid_scope"})
+	require.Len(t, h, 1)
+
+	h = hints(&OAuthError{
+		Code:        "invalid_request",
+		Description: "AADSTS50011: The redirect URI 'http://localhost:4447/callback' specified in the request does not match",
+	})
+	require.Len(t, h, 2)
+	require.Contains(t, h[1], "AADSTS50011")
+
+	require.Empty(t, hints(&OAuthError{Code: "something_custom"}))
+}
@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const introspectionJWT = "application/token-introspection+jwt"
+
+// IntrospectionReport holds the response from the introspection endpoint,
+// https://tools.ietf.org/html/rfc7662#section-2.2
+type IntrospectionReport struct {
+	EndpointReport
+
+	TokenTypeHint string
+
+	// JWT is the decoded response when the provider returned a JWT,
+	// Fields then holds its token_introspection claim,
+	// https://www.rfc-editor.org/rfc/rfc9701#section-5
+	JWT *TokenReport
+
+	Active bool
+
+	Checks []Check
+}
+
+// introspectionFields are the response fields defined by RFC 7662, any
+// others are extensions added by the provider.
+var introspectionFields = []string{
+	"active", "scope", "client_id", "username", "token_type",
+	"exp", "iat", "nbf", "sub", "aud", "iss", "jti",
+}
+
+// Extensions returns the names of the response fields that are not
+// defined by RFC 7662, sorted by name.
+func (i *IntrospectionReport) Extensions() []string {
+	var names []string
+	for name := range i.Fields {
+		if !contains(introspectionFields, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checks returns the introspection's checks and those of the JWT response,
+// nil when there is no introspection.
+func (i *IntrospectionReport) checks() []Check {
+	if i == nil {
+		return nil
+	}
+	checks := append(i.ClientAssertion.checks(), i.Checks...)
+	return append(checks, i.JWT.checks()...)
+}
+
+var introspectCmd = &cobra.Command{
+	Use:   "introspect",
+	Short: "Introspect a token issued by OIDC server",
+	Long: `Asks the introspection endpoint whether a token is active and what it grants.
+Without --token the login is run first, as the test command does, and the tokens it issued are introspected.
+With --jwt a signed JWT response is requested, https://www.rfc-editor.org/rfc/rfc9701, and its signature, iss and aud are checked.`,
+	Run: introspect,
+}
+
+var (
+	introspectConfigFile string
+	introspectTimeout    time.Duration
+	introspectToken      string
+	introspectHint       string
+	introspectJWT        bool
+)
+
+func init() {
+	f := introspectCmd.Flags()
+	f.StringVarP(&introspectConfigFile, "config", "c", "", "")
+	f.DurationVar(&introspectTimeout, "timeout", 0, "how long to wait for the login to complete, overrides the config")
+	f.StringVar(&introspectToken, "token", "", "the token to introspect, - reads it from stdin")
+	f.StringVar(&introspectHint, "hint", "", "the token_type_hint, access_token or refresh_token")
+	f.BoolVar(&introspectJWT, "jwt", false, "ask for a signed JWT response")
+	introspectCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(introspectCmd)
+}
+
+func introspect(cmd *cobra.Command, args []string) {
+	runCommand(cmd, introspectConfigFile, introspectTimeout, func(ctx context.Context, cfg TestConfig) *Report {
+		cfg.IntrospectJWT = cfg.IntrospectJWT || introspectJWT
+
+		if introspectToken == "" {
+			cfg.Introspect = true
+			return TestContext(ctx, cfg)
+		}
+
+		token, err := readToken(introspectToken)
+		if err != nil {
+			return &Report{Err: err}
+		}
+
+		return IntrospectContext(ctx, cfg, token, introspectHint)
+	})
+}
+
+// Introspect asks the introspection endpoint about the token, hint is the
+// optional token_type_hint. Set cfg.Introspect to introspect the tokens
+// issued by a login instead.
+func Introspect(cfg TestConfig, token, hint string) *Report {
+	return IntrospectContext(context.Background(), cfg, token, hint)
+}
+
+// IntrospectContext is Introspect with a context to cancel the request, it
+// is also cancelled when cfg.Timeout passes.
+func IntrospectContext(ctx context.Context, cfg TestConfig, token, hint string) *Report {
+	return run(ctx, cfg, func(f *flow) {
+		f.report.Introspection = append(f.report.Introspection, f.introspect(token, hint))
+	})
+}
+
+// introspectTokens introspects the access and refresh tokens issued by
+// the flow.
+func (f *flow) introspectTokens() {
+	if f.accessToken != "" {
+		f.report.Introspection = append(f.report.Introspection, f.introspect(f.accessToken, "access_token"))
+	}
+	if t := f.report.Token; t != nil && t.RefreshToken != "" {
+		f.report.Introspection = append(f.report.Introspection, f.introspect(t.RefreshToken, "refresh_token"))
+	}
+}
+
+// introspect posts the token to the introspection endpoint,
+// https://tools.ietf.org/html/rfc7662#section-2.1
+func (f *flow) introspect(token, hint string) *IntrospectionReport {
+	m := f.report.Provider.Metadata
+	if m.IntrospectionEndpoint == "" {
+		return &IntrospectionReport{
+			TokenTypeHint:  hint,
+			EndpointReport: EndpointReport{Err: errors.New("provider does not advertise an introspection_endpoint")},
+		}
+	}
+
+	form := url.Values{"token": {token}}
+	if hint != "" {
+		form.Set("token_type_hint", hint)
+	}
+
+	accept := "application/json"
+	if f.cfg.IntrospectJWT {
+		accept = introspectionJWT
+	}
+
+	endpoint := m.endpoint("introspection_endpoint", m.IntrospectionEndpoint, f.certThumbprint != "")
+	i := &IntrospectionReport{
+		EndpointReport: postForm(f.ctx, f.client, &f.cfg, endpoint, form, accept),
+		TokenTypeHint:  hint,
+	}
+	if i.Err != nil {
+		return i
+	}
+
+	raw := string(bytes.TrimSpace(i.Raw))
+	switch {
+	case i.ContentType == introspectionJWT || isJWT(raw):
+		i.JWT = f.decodeIntrospectionJWT(raw)
+		if i.JWT.Claims == nil {
+			i.Err = errors.New("could not decode the JWT introspection response")
+			return i
+		}
+
+		var ok bool
+		i.Fields, ok = i.JWT.Claims["token_introspection"].(map[string]interface{})
+		if !ok {
+			i.Err = errors.New("JWT introspection response does not contain a token_introspection object")
+			return i
+		}
+	default:
+		i.Err = i.decodeFields()
+		if i.Err != nil {
+			return i
+		}
+		if f.cfg.IntrospectJWT {
+			i.Checks = append(i.Checks, fail("jwt", "a JWT response was requested but the provider returned [%s]", i.ContentType))
+		}
+	}
+
+	var ok bool
+	i.Active, ok = i.Fields["active"].(bool)
+	if !ok {
+		i.Err = fmt.Errorf("introspection response does not contain a boolean active field: %v", i.Fields["active"])
+		return i
+	}
+
+	i.Checks = append(i.Checks, checkIntrospection(i.Fields, f.expectations())...)
+	return i
+}
+
+// decodeIntrospectionJWT verifies a JWT introspection response,
+// https://www.rfc-editor.org/rfc/rfc9701#section-5
+func (f *flow) decodeIntrospectionJWT(raw string) *TokenReport {
+	t := f.decodeVerified(raw, f.report.Provider.Metadata.IntrospectionSigningAlgs)
+	if t.Claims == nil {
+		return t
+	}
+
+	e := f.expectations()
+	t.Checks = append(t.Checks,
+		checkIntrospectionType(t.JOSE.Type),
+		checkIssuer(t.Claims["iss"], e.Issuer),
+		checkAudience(audience(t.Claims["aud"]), e.ClientID),
+		checkIssuedAt(t.Claims["iat"], e.Now, e.ClockSkew),
+	)
+	return t
+}
+
+func checkIntrospectionType(typ string) Check {
+	const name = "typ"
+	switch strings.ToLower(typ) {
+	case "token-introspection+jwt", introspectionJWT:
+		return pass(name, "[%s]", typ)
+	case "":
+		return fail(name, "header does not contain a typ, expected [token-introspection+jwt]")
+	default:
+		return fail(name, "[%s] expected [token-introspection+jwt]", typ)
+	}
+}
+
+// checkIntrospection checks the expiry of an active token. An inactive
+// token should not be described, https://tools.ietf.org/html/rfc7662#section-2.2
+func checkIntrospection(fields map[string]interface{}, e claimExpectations) []Check {
+	if active, _ := fields["active"].(bool); !active {
+		if len(fields) > 1 {
+			return []Check{fail("active", "the token is not active but the response includes other fields")}
+		}
+		return []Check{pass("active", "the token is not active")}
+	}
+
+	checks := []Check{pass("active", "the token is active")}
+	if _, ok := fields["exp"]; ok {
+		checks = append(checks, checkExpiry(fields["exp"], e.Now, e.ClockSkew))
+	}
+	if _, ok := fields["nbf"]; ok {
+		checks = append(checks, checkNotBefore(fields["nbf"], e.Now, e.ClockSkew))
+	}
+	return checks
+}
+
+func printIntrospection(w io.Writer, i *IntrospectionReport, showSecrets bool) {
+	if i.URL != "" {
+		printEndpoint(w, &i.EndpointReport, showSecrets)
+	}
+	if i.TokenTypeHint != "" {
+		fmt.Fprintf(w, "  Hint:      %s\n", i.TokenTypeHint)
+	}
+
+	if i.JWT != nil {
+		fmt.Fprintln(w, "  JWT response:")
+		printToken(w, i.JWT)
+	}
+
+	if i.Fields != nil {
+		fmt.Fprintf(w, "  Active:    %t\n", i.Active)
+		if scope, ok := i.Fields["scope"].(string); ok {
+			fmt.Fprintf(w, "  Scope:     %s\n", scope)
+		}
+		for _, name := range []string{"iat", "nbf", "exp"} {
+			if t, ok := numericDate(i.Fields[name]); ok {
+				fmt.Fprintf(w, "  %-10s %s (%s)\n", name+":", formatTime(t), t.UTC().Format(time.RFC3339))
+			}
+		}
+		for _, name := range i.Extensions() {
+			fmt.Fprintf(w, "  Extension %s: %s\n", name, claimValue(i.Fields[name], true))
+		}
+	}
+
+	printChecks(w, i.Checks)
+	printErr(w, i.Err)
+}
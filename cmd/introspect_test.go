@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestIntrospect(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.Introspect = true
+
+	report := Test(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+	require.Len(t, report.Introspection, 2)
+
+	access := report.Introspection[0]
+	require.Equal(t, ts.URL+"/oauth2/introspect", access.URL)
+	require.Equal(t, "access_token", access.TokenTypeHint)
+	require.True(t, access.Active)
+	require.Nil(t, access.JWT)
+	require.Equal(t, []string{"group"}, access.Extensions())
+	requireCheck(t, access.Checks, "active", true)
+	requireCheck(t, access.Checks, "exp", true)
+
+	refresh := report.Introspection[1]
+	require.Equal(t, "refresh_token", refresh.TokenTypeHint)
+	require.True(t, refresh.Active)
+	require.Equal(t, "refresh_token", refresh.Fields["token_type"])
+}
+
+func TestIntrospect(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	login := Test(cfg)
+	require.False(t, login.Failed(), "%v", login.Err)
+
+	t.Run("jwt", func(t *testing.T) {
+		cfg := cfg
+		cfg.IntrospectJWT = true
+
+		report := Introspect(cfg, login.Token.AccessToken, "")
+		require.False(t, report.Failed(), "%v", report.Err)
+
+		i := report.Introspection[0]
+		require.Equal(t, introspectionJWT, i.ContentType)
+		require.NotNil(t, i.JWT)
+		requireCheck(t, i.JWT.Checks, "typ", true)
+		requireCheck(t, i.JWT.Checks, "iss", true)
+		requireCheck(t, i.JWT.Checks, "aud", true)
+		require.True(t, i.Active)
+		require.Equal(t, "testing", i.Fields["client_id"])
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		report := Introspect(cfg, "not-a-token", "access_token")
+		require.False(t, report.Failed(), "%v", report.Err)
+
+		i := report.Introspection[0]
+		require.False(t, i.Active)
+		requireCheck(t, i.Checks, "active", true)
+	})
+
+	t.Run("public client", func(t *testing.T) {
+		cfg := cfg
+		cfg.ClientSecret = ""
+
+		report := Introspect(cfg, login.Token.AccessToken, "")
+		require.True(t, report.Failed())
+		require.Contains(t, report.Introspection[0].Err.Error(), "invalid_client")
+	})
+}
+
+func TestCheckIntrospection(t *testing.T) {
+	e := claimExpectations{Now: time.Now()}
+	exp := float64(e.Now.Add(time.Minute).Unix())
+
+	require.True(t, checkIntrospection(map[string]interface{}{"active": false}, e)[0].OK)
+	require.False(t, checkIntrospection(map[string]interface{}{"active": false, "sub": "a"}, e)[0].OK)
+
+	checks := checkIntrospection(map[string]interface{}{"active": true, "exp": exp}, e)
+	requireCheck(t, checks, "active", true)
+	requireCheck(t, checks, "exp", true)
+
+	checks = checkIntrospection(map[string]interface{}{"active": true, "exp": float64(e.Now.Add(-time.Hour).Unix())}, e)
+	requireCheck(t, checks, "exp", false)
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// SignatureReport describes the result of verifying a JWT against the
+// provider's JWKS.
+type SignatureReport struct {
+	// KeyID and Algorithm are taken from the JOSE header of the token.
+	KeyID     string
+	Algorithm string
+
+	// MatchedKey is the kid of the JWKS key that verified the signature
+	// and Thumbprint its RFC 7638 SHA-256 thumbprint.
+	MatchedKey string
+	Thumbprint string
+
+	Verified bool
+	Err      error
+}
+
+func fetchJWKS(ctx context.Context, client *http.Client, jwksURL string) (*jose.JSONWebKeySet, error) {
+	if jwksURL == "" {
+		return nil, errors.New("provider did not advertise a jwks_uri")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating JWKS request: %w", err)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching JWKS: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading JWKS: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching JWKS: %s: %s", res.Status, body)
+	}
+
+	var keys jose.JSONWebKeySet
+	err = json.Unmarshal(body, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JWKS: %w", err)
+	}
+
+	return &keys, nil
+}
+
+// verifySignature checks the signature of raw against the keys. When the
+// token has a kid only keys with that kid are tried, otherwise every key
+// in the set is tried.
+func verifySignature(raw string, keys *jose.JSONWebKeySet, jwksErr error) *SignatureReport {
+	report := &SignatureReport{}
+
+	jws, err := jose.ParseSigned(raw)
+	if err != nil {
+		report.Err = fmt.Errorf("could not parse JWS: %w", err)
+		return report
+	}
+
+	if len(jws.Signatures) != 1 {
+		report.Err = fmt.Errorf("expected a single signature, token has %d", len(jws.Signatures))
+		return report
+	}
+
+	header := jws.Signatures[0].Header
+	report.KeyID = header.KeyID
+	report.Algorithm = header.Algorithm
+
+	if jwksErr != nil {
+		report.Err = fmt.Errorf("could not load JWKS: %w", jwksErr)
+		return report
+	}
+
+	candidates := keys.Keys
+	if header.KeyID != "" {
+		candidates = keys.Key(header.KeyID)
+		if len(candidates) == 0 {
+			report.Err = fmt.Errorf("no key with kid [%s] in JWKS", header.KeyID)
+			return report
+		}
+	}
+
+	if len(candidates) == 0 {
+		report.Err = errors.New("JWKS does not contain any keys")
+		return report
+	}
+
+	var reasons []string
+	for _, key := range candidates {
+		if key.Use != "" && key.Use != "sig" {
+			reasons = append(reasons, fmt.Sprintf("key [%s] has use [%s]", key.KeyID, key.Use))
+			continue
+		}
+
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			reasons = append(reasons, fmt.Sprintf("key [%s] is for alg [%s] not [%s]", key.KeyID, key.Algorithm, header.Algorithm))
+			continue
+		}
+
+		_, err := jws.Verify(key)
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("key [%s]: %v", key.KeyID, err))
+			continue
+		}
+
+		report.Verified = true
+		report.MatchedKey = key.KeyID
+		report.Thumbprint = thumbprint(key)
+		return report
+	}
+
+	report.Err = fmt.Errorf("signature did not verify with any key: %s", strings.Join(reasons, "; "))
+	return report
+}
+
+func thumbprint(key jose.JSONWebKey) string {
+	b, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestVerifySignature(t *testing.T) {
+	signing := newTestKey(t, "a")
+	other := newTestKey(t, "b")
+	raw := signTestToken(t, signing, `{"sub":"someone@test"}`)
+
+	t.Run("matching key", func(t *testing.T) {
+		keys := &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{other.Public(), signing.Public()}}
+		report := verifySignature(raw, keys, nil)
+		require.NoError(t, report.Err)
+		require.True(t, report.Verified)
+		require.Equal(t, "a", report.MatchedKey)
+		require.Equal(t, "RS256", report.Algorithm)
+	})
+
+	t.Run("unknown kid", func(t *testing.T) {
+		keys := &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{other.Public()}}
+		report := verifySignature(raw, keys, nil)
+		require.False(t, report.Verified)
+		require.EqualError(t, report.Err, "no key with kid [a] in JWKS")
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		wrong := other.Public()
+		wrong.KeyID = "a"
+		keys := &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{wrong}}
+		report := verifySignature(raw, keys, nil)
+		require.False(t, report.Verified)
+		require.Error(t, report.Err)
+	})
+}
+
+func newTestKey(t *testing.T, kid string) *jose.JSONWebKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return &jose.JSONWebKey{Key: key, KeyID: kid, Algorithm: string(jose.RS256), Use: "sig"}
+}
+
+func signTestToken(t *testing.T, key *jose.JSONWebKey, payload string) string {
+	t.Helper()
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, new(jose.SignerOptions).WithType("JWT"))
+	require.NoError(t, err)
+	jws, err := sig.Sign([]byte(payload))
+	require.NoError(t, err)
+	raw, err := jws.CompactSerialize()
+	require.NoError(t, err)
+	return raw
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// LogoutReport holds the RP-initiated logout request and where the provider
+// redirected the browser back to,
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html
+type LogoutReport struct {
+	URL                   string
+	Params                url.Values
+	State                 string
+	PostLogoutRedirectURI string
+
+	// Callback is the URL the provider redirected back to and Response
+	// its query parameters.
+	Callback string
+	Response url.Values
+
+	Checks []Check
+	Err    error
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Test signing out of OIDC server",
+	Long: `Sends the browser to the end_session_endpoint and waits for the provider to redirect back to the local server.
+Without --id-token the login is run first, as the test command does, and its ID token is sent as the id_token_hint.
+The post_logout_redirect_uri is the logoutCallbackPath of the local server and must be registered with the provider.`,
+	Run: logout,
+}
+
+var (
+	logoutConfigFile string
+	logoutTimeout    time.Duration
+	logoutIDToken    string
+)
+
+func init() {
+	f := logoutCmd.Flags()
+	f.StringVarP(&logoutConfigFile, "config", "c", "", "")
+	f.DurationVar(&logoutTimeout, "timeout", 0, "how long to wait for the login and logout to complete, overrides the config")
+	f.StringVar(&logoutIDToken, "id-token", "", "the ID token to send as the id_token_hint, - reads it from stdin")
+	logoutCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(logoutCmd)
+}
+
+func logout(cmd *cobra.Command, args []string) {
+	runCommand(cmd, logoutConfigFile, logoutTimeout, func(ctx context.Context, cfg TestConfig) *Report {
+		if logoutIDToken == "" {
+			cfg.Logout = true
+			return TestContext(ctx, cfg)
+		}
+
+		idToken, err := readToken(logoutIDToken)
+		if err != nil {
+			return &Report{Err: err}
+		}
+
+		return LogoutContext(ctx, cfg, idToken)
+	})
+}
+
+// Logout signs out at the provider's end_session_endpoint, idToken is sent
+// as the id_token_hint when it is not empty. Set cfg.Logout to log out at
+// the end of a login instead.
+func Logout(cfg TestConfig, idToken string) *Report {
+	return LogoutContext(context.Background(), cfg, idToken)
+}
+
+// LogoutContext is Logout with a context to cancel the logout, it is also
+// cancelled when cfg.Timeout passes.
+func LogoutContext(ctx context.Context, cfg TestConfig, idToken string) *Report {
+	return run(ctx, cfg, func(f *flow) {
+		f.logout(idToken)
+	})
+}
+
+// logout sends the browser to the end_session_endpoint with the local
+// server as the post_logout_redirect_uri, then waits for the redirect,
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html#RPLogout
+func (f *flow) logout(idToken string) {
+	l := &LogoutReport{}
+	f.report.Logout = l
+
+	m := f.report.Provider.Metadata
+	if m.EndSessionEndpoint == "" {
+		l.Err = errors.New("provider does not advertise an end_session_endpoint")
+		return
+	}
+
+	endpoint, err := url.Parse(m.EndSessionEndpoint)
+	if err != nil {
+		l.Err = fmt.Errorf("end_session_endpoint is invalid: %w", err)
+		return
+	}
+
+	l.State, err = randomString(16)
+	if err != nil {
+		l.Err = fmt.Errorf("could not generate state: %w", err)
+		return
+	}
+
+	if idToken == "" {
+		l.Checks = append(l.Checks, pass("id_token_hint", "not sent, the provider may ask the user to confirm the logout"))
+	}
+
+	f.logoutDone = make(chan struct{})
+	err = f.openBrowser("logout", f.logoutDone, "the provider to redirect to the post_logout_redirect_uri", func(base *url.URL) {
+		l.PostLogoutRedirectURI = base.ResolveReference(&url.URL{Path: f.cfg.logoutCallbackPath()}).String()
+
+		l.Params = url.Values{
+			"client_id":                {f.cfg.ClientID},
+			"post_logout_redirect_uri": {l.PostLogoutRedirectURI},
+			"state":                    {l.State},
+		}
+		if idToken != "" {
+			l.Params.Set("id_token_hint", idToken)
+		}
+
+		q := endpoint.Query()
+		for k, v := range l.Params {
+			q[k] = v
+		}
+		endpoint.RawQuery = q.Encode()
+		l.URL = endpoint.String()
+	})
+	if err != nil {
+		l.Err = err
+	}
+}
+
+func (f *flow) handleLogout(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+	case http.MethodGet:
+		f.mu.Lock()
+		l := f.report.Logout
+		f.mu.Unlock()
+
+		if l == nil || l.URL == "" {
+			http.Error(w, "oidcdebug is not logging out", http.StatusNotFound)
+			return
+		}
+
+		http.Redirect(w, r, l.URL, http.StatusFound)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleLogoutCallback receives the redirect to the post_logout_redirect_uri,
+// only the first redirect is recorded.
+func (f *flow) handleLogoutCallback(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+	case http.MethodGet:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		l := f.report.Logout
+		if l == nil || f.logoutDone == nil {
+			http.Error(w, "oidcdebug is not logging out", http.StatusNotFound)
+			return
+		}
+
+		select {
+		case <-f.logoutDone:
+			http.Error(w, "oidcdebug has already received a response from the provider", http.StatusConflict)
+			return
+		default:
+		}
+
+		l.Callback = r.URL.String()
+		l.Response = r.URL.Query()
+		l.Checks = append(l.Checks,
+			pass("redirect", "the provider redirected back to the post_logout_redirect_uri"),
+			checkState(l.State, l.Response.Get("state")),
+		)
+
+		writePage(w, "Logout complete", "You can close this window and return to oidcdebug.", nil)
+		close(f.logoutDone)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// printLogout writes the logout request, the id_token_hint is redacted
+// unless showSecrets is set.
+func printLogout(w io.Writer, l *LogoutReport, showSecrets bool) {
+	if l.URL != "" {
+		params := l.Params
+		if hint := params.Get("id_token_hint"); hint != "" && !showSecrets {
+			params = url.Values{}
+			for k, v := range l.Params {
+				params[k] = v
+			}
+			params.Set("id_token_hint", redact(hint))
+		}
+
+		fmt.Fprintf(w, "  URL:       %s\n", strings.SplitN(l.URL, "?", 2)[0])
+		printParams(w, params)
+		fmt.Fprintf(w, "  State:     %s\n", l.State)
+	}
+	if l.Callback != "" {
+		fmt.Fprintf(w, "  Callback:  %s\n", l.Callback)
+		printParams(w, l.Response)
+	}
+	printChecks(w, l.Checks)
+	printErr(w, l.Err)
+}
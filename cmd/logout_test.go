@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestLogout(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.Logout = true
+
+	report := Test(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+
+	l := report.Logout
+	require.NotNil(t, l)
+	require.Equal(t, report.Token.IDToken, l.Params.Get("id_token_hint"))
+	require.Equal(t, "http://localhost:4447/logout/callback", l.PostLogoutRedirectURI)
+	require.Equal(t, l.State, l.Response.Get("state"))
+	requireCheck(t, l.Checks, "redirect", true)
+	requireCheck(t, l.Checks, "state", true)
+}
+
+func TestLogout(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.LogoutCallbackPath = "/signed-out"
+
+	report := Logout(cfg, "")
+	require.False(t, report.Failed(), "%v", report.Err)
+	require.Equal(t, "http://localhost:4447/signed-out", report.Logout.PostLogoutRedirectURI)
+	requireCheck(t, report.Logout.Checks, "id_token_hint", true)
+	requireCheck(t, report.Logout.Checks, "state", true)
+
+	// the mock rejects the hint so never redirects back
+	report = Logout(cfg, "not-a-jwt")
+	require.True(t, report.Failed())
+	require.Error(t, report.Logout.Err)
+	require.Nil(t, report.Logout.Response)
+}
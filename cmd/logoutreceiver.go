@@ -0,0 +1,381 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	backChannel  = "back-channel"
+	frontChannel = "front-channel"
+
+	// backchannelLogoutEvent is the member of the events claim that marks
+	// a JWT as a logout token.
+	backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+)
+
+// LogoutNotificationReport holds a logout notification the provider sent to
+// the local server,
+// https://openid.net/specs/openid-connect-backchannel-1_0.html and
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html
+type LogoutNotificationReport struct {
+	// Channel is back-channel or front-channel.
+	Channel  string
+	Received time.Time
+
+	// Request is the method and URL the notification was sent to, Params
+	// the front-channel query parameters.
+	Request string
+	Params  url.Values
+
+	// LogoutToken is the decoded back-channel logout_token.
+	LogoutToken *TokenReport
+
+	Checks []Check
+	Err    error
+}
+
+var logoutReceiverCmd = &cobra.Command{
+	Use:   "logout-receiver",
+	Short: "Receive logout notifications from OIDC server",
+	Long: `Starts the local server and waits for the provider to send back-channel or front-channel logout notifications.
+Register the printed URIs with the provider as the client's backchannel_logout_uri and frontchannel_logout_uri, then log out at the provider.
+A logout_token is checked for its signature, iss, aud, iat, events, a sub or sid and the absence of a nonce, and front-channel iss and sid parameters are checked the same way.
+Notifications sent while the test or logout commands run are also received.`,
+	Run: logoutReceiver,
+}
+
+var (
+	logoutReceiverConfigFile string
+	logoutReceiverTimeout    time.Duration
+	logoutReceiverCount      int
+)
+
+func init() {
+	f := logoutReceiverCmd.Flags()
+	f.StringVarP(&logoutReceiverConfigFile, "config", "c", "", "")
+	f.DurationVar(&logoutReceiverTimeout, "timeout", 0, "how long to wait for notifications, overrides the config")
+	f.IntVar(&logoutReceiverCount, "count", 0, "stop once this many notifications have been received, 0 waits until the timeout or Ctrl-C")
+	logoutReceiverCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(logoutReceiverCmd)
+}
+
+func logoutReceiver(cmd *cobra.Command, args []string) {
+	runCommand(cmd, logoutReceiverConfigFile, logoutReceiverTimeout, func(ctx context.Context, cfg TestConfig) *Report {
+		cfg.LogoutReceiverReady = func(backchannelURI, frontchannelURI string) {
+			printLogoutReceiverPrompt(os.Stdout, backchannelURI, frontchannelURI)
+		}
+		return ReceiveLogoutContext(ctx, cfg, logoutReceiverCount)
+	})
+}
+
+// ReceiveLogout starts the local server and records the logout
+// notifications sent to it until count have been received, or until
+// cfg.Timeout passes when count is 0.
+func ReceiveLogout(cfg TestConfig, count int) *Report {
+	return ReceiveLogoutContext(context.Background(), cfg, count)
+}
+
+// ReceiveLogoutContext is ReceiveLogout with a context to stop listening.
+// The context finishing is how listening normally ends, so it is not
+// reported as an error.
+func ReceiveLogoutContext(ctx context.Context, cfg TestConfig, count int) *Report {
+	return run(ctx, cfg, func(f *flow) {
+		f.receiveLogout(count)
+	})
+}
+
+func (f *flow) receiveLogout(count int) {
+	f.notificationLimit = count
+	f.notificationsDone = make(chan struct{})
+
+	err := f.serveLocal(f.notificationsDone, "logout notifications", func(base *url.URL) error {
+		if f.cfg.LogoutReceiverReady != nil {
+			f.cfg.LogoutReceiverReady(
+				base.ResolveReference(&url.URL{Path: "/backchannel-logout"}).String(),
+				base.ResolveReference(&url.URL{Path: "/frontchannel-logout"}).String(),
+			)
+		}
+		return nil
+	})
+	if err != nil && f.ctx.Err() == nil {
+		f.report.Err = err
+	}
+}
+
+// addNotification records the notification, closing notificationsDone once
+// the limit is reached. The caller must hold f.mu.
+func (f *flow) addNotification(n *LogoutNotificationReport) {
+	f.report.LogoutNotifications = append(f.report.LogoutNotifications, n)
+
+	if f.notificationsDone == nil || f.notificationLimit <= 0 || len(f.report.LogoutNotifications) != f.notificationLimit {
+		return
+	}
+	close(f.notificationsDone)
+}
+
+// handleBackchannelLogout receives the logout_token,
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#BCRequest
+// The response tells the provider if the token was accepted,
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#BCResponse
+func (f *flow) handleBackchannelLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := &LogoutNotificationReport{
+		Channel:  backChannel,
+		Received: time.Now(),
+		Request:  "POST " + r.URL.String(),
+	}
+
+	if raw := r.PostForm.Get("logout_token"); raw == "" {
+		n.Err = errors.New("request does not contain a logout_token")
+	} else {
+		n.LogoutToken = f.decodeLogoutToken(raw)
+		if n.LogoutToken.Claims == nil {
+			n.Err = fmt.Errorf("invalid logout_token: %w", n.LogoutToken.Err)
+		}
+	}
+	f.addNotification(n)
+
+	w.Header().Set("Cache-Control", "no-store")
+	if n.Err == nil && allPassed(n.LogoutToken.Checks) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	description := "the logout_token failed validation, see the oidcdebug output"
+	if n.Err != nil {
+		description = n.Err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             "invalid_request",
+		"error_description": description,
+	})
+}
+
+// handleFrontchannelLogout is the page the provider loads in an iframe,
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html#RPLogout
+func (f *flow) handleFrontchannelLogout(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+	case http.MethodGet:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		n := &LogoutNotificationReport{
+			Channel:  frontChannel,
+			Received: time.Now(),
+			Request:  "GET " + r.URL.String(),
+			Params:   r.URL.Query(),
+		}
+		n.Checks = checkFrontchannelParams(n.Params, f.cfg.IssuerURL, f.idTokenClaims(),
+			f.report.Provider.Metadata.FrontchannelLogoutSessionSupported)
+		f.addNotification(n)
+
+		w.Header().Set("Cache-Control", "no-store")
+		writePage(w, "Logged out", "oidcdebug received the front-channel logout notification.", nil)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// idTokenClaims returns the claims of the ID token from the login, nil
+// when there was no login.
+func (f *flow) idTokenClaims() map[string]interface{} {
+	if t := f.report.IDToken; t != nil {
+		return t.Claims
+	}
+	return nil
+}
+
+// decodeLogoutToken validates the logout token,
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#Validation
+func (f *flow) decodeLogoutToken(raw string) *TokenReport {
+	m := f.report.Provider.Metadata
+	t := f.decodeVerified(raw, m.IDTokenSigningAlgs)
+	if t.Claims == nil {
+		return t
+	}
+
+	e := f.expectations()
+	t.Checks = append(t.Checks,
+		checkLogoutTokenType(t.JOSE.Type),
+		checkIssuer(t.Claims["iss"], e.Issuer),
+		checkAudience(audience(t.Claims["aud"]), e.ClientID),
+		checkIssuedAt(t.Claims["iat"], e.Now, e.ClockSkew),
+	)
+	if exp, ok := t.Claims["exp"]; ok {
+		t.Checks = append(t.Checks, checkExpiry(exp, e.Now, e.ClockSkew))
+	}
+	t.Checks = append(t.Checks, checkLogoutClaims(t.Claims, f.idTokenClaims(), m.BackchannelLogoutSessionSupported)...)
+	return t
+}
+
+func checkLogoutTokenType(typ string) Check {
+	const name = "typ"
+	switch typ {
+	case "logout+jwt", "application/logout+jwt":
+		return pass(name, "[%s]", typ)
+	case "":
+		return pass(name, "header does not contain a typ, logout+jwt is recommended")
+	default:
+		return fail(name, "[%s] expected [logout+jwt]", typ)
+	}
+}
+
+// checkLogoutClaims checks the claims specific to logout tokens. The sub and
+// sid are compared with the ID token from the login when there is one,
+// sessionSupported is the provider's backchannel_logout_session_supported.
+func checkLogoutClaims(claims, idClaims map[string]interface{}, sessionSupported bool) []Check {
+	checks := []Check{checkLogoutEvent(claims["events"])}
+
+	if _, ok := claims["jti"].(string); ok {
+		checks = append(checks, pass("jti", "present"))
+	} else {
+		checks = append(checks, fail("jti", "logout token does not contain a jti claim"))
+	}
+
+	if _, ok := claims["nonce"]; ok {
+		checks = append(checks, fail("nonce", "logout token must not contain a nonce, it could be mistaken for an ID token"))
+	} else {
+		checks = append(checks, pass("nonce", "not present"))
+	}
+
+	_, hasSub := claims["sub"]
+	_, hasSid := claims["sid"]
+	if !hasSub && !hasSid {
+		return append(checks, fail("sub", "logout token must contain a sub or sid claim"))
+	}
+	if hasSub {
+		checks = append(checks, checkSessionClaim("sub", claims["sub"], idClaims))
+	}
+	switch {
+	case hasSid:
+		checks = append(checks, checkSessionClaim("sid", claims["sid"], idClaims))
+	case sessionSupported:
+		checks = append(checks, fail("sid", "not present, the provider advertises backchannel_logout_session_supported"))
+	}
+	return checks
+}
+
+func checkLogoutEvent(raw interface{}) Check {
+	const name = "events"
+	events, ok := raw.(map[string]interface{})
+	switch {
+	case raw == nil:
+		return fail(name, "logout token does not contain an events claim")
+	case !ok:
+		return fail(name, "events claim is not an object: %v", raw)
+	}
+
+	event, ok := events[backchannelLogoutEvent]
+	switch {
+	case !ok:
+		return fail(name, "does not contain [%s]", backchannelLogoutEvent)
+	case event == nil:
+		return fail(name, "[%s] is null, expected an object", backchannelLogoutEvent)
+	default:
+		if _, ok := event.(map[string]interface{}); !ok {
+			return fail(name, "[%s] is not an object: %v", backchannelLogoutEvent, event)
+		}
+		return pass(name, "contains [%s]", backchannelLogoutEvent)
+	}
+}
+
+// checkSessionClaim compares the sub or sid sent by the provider with the
+// same claim in the ID token from the login.
+func checkSessionClaim(name string, raw interface{}, idClaims map[string]interface{}) Check {
+	value, ok := raw.(string)
+	if !ok {
+		return fail(name, "%s is not a string: %v", name, raw)
+	}
+
+	expected, ok := idClaims[name].(string)
+	switch {
+	case idClaims == nil:
+		return pass(name, "[%s]", value)
+	case !ok:
+		return pass(name, "[%s], not compared as the ID token does not contain a %s", value, name)
+	case value == expected:
+		return pass(name, "[%s] matches the ID token", value)
+	default:
+		return fail(name, "[%s] does not match the ID token [%s], the notification is for another session", value, expected)
+	}
+}
+
+// checkFrontchannelParams checks the iss and sid query parameters, which
+// are required when the provider advertises frontchannel_logout_session_supported.
+func checkFrontchannelParams(params url.Values, issuer string, idClaims map[string]interface{}, sessionSupported bool) []Check {
+	var checks []Check
+	for _, name := range []string{"iss", "sid"} {
+		if _, ok := params[name]; ok {
+			continue
+		}
+		if sessionSupported {
+			checks = append(checks, fail(name, "not sent, the provider advertises frontchannel_logout_session_supported"))
+		} else {
+			checks = append(checks, pass(name, "not sent"))
+		}
+	}
+
+	if _, ok := params["iss"]; ok {
+		checks = append(checks, checkIssuer(params.Get("iss"), issuer))
+	}
+	if _, ok := params["sid"]; ok {
+		checks = append(checks, checkSessionClaim("sid", params.Get("sid"), idClaims))
+	}
+	return checks
+}
+
+func allPassed(checks []Check) bool {
+	for _, c := range checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// printLogoutReceiverPrompt tells the user which URIs to register with the
+// provider.
+func printLogoutReceiverPrompt(w io.Writer, backchannelURI, frontchannelURI string) {
+	fmt.Fprintln(w, "Waiting for logout notifications, register these with the provider")
+	fmt.Fprintf(w, "  backchannel_logout_uri:  %s\n", backchannelURI)
+	fmt.Fprintf(w, "  frontchannel_logout_uri: %s\n", frontchannelURI)
+}
+
+func printLogoutNotification(w io.Writer, n *LogoutNotificationReport) {
+	fmt.Fprintf(w, "  Received:  %s\n", formatTime(n.Received))
+	fmt.Fprintf(w, "  Request:   %s\n", n.Request)
+	printParams(w, n.Params)
+	printChecks(w, n.Checks)
+	printErr(w, n.Err)
+
+	if n.LogoutToken != nil {
+		fmt.Fprintln(w, "Logout token")
+		printToken(w, n.LogoutToken)
+	}
+}
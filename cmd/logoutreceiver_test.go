@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogoutBackchannelNotification(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.Logout = true
+
+	report := Test(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+
+	// the mock posts a logout token for the id_token_hint's session
+	require.Len(t, report.LogoutNotifications, 1)
+	n := report.LogoutNotifications[0]
+	require.Equal(t, backChannel, n.Channel)
+	require.NotNil(t, n.LogoutToken)
+	require.NoError(t, n.Err)
+	for _, name := range []string{"typ", "iss", "aud", "iat", "events", "jti", "nonce", "sub", "sid"} {
+		requireCheck(t, n.LogoutToken.Checks, name, true)
+	}
+	require.Equal(t, report.IDToken.Claims["sid"], n.LogoutToken.Claims["sid"])
+}
+
+func TestReceiveLogout(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	var statuses []int
+	cfg := testConfig(ts)
+	cfg.LogoutReceiverReady = func(backchannelURI, frontchannelURI string) {
+		require.Equal(t, "http://localhost:4447/backchannel-logout", backchannelURI)
+
+		res, err := http.Get(frontchannelURI + "?" + url.Values{"iss": {cfg.IssuerURL}, "sid": {"abc"}}.Encode())
+		require.NoError(t, err)
+		res.Body.Close()
+		statuses = append(statuses, res.StatusCode)
+
+		res, err = http.PostForm(backchannelURI, url.Values{"logout_token": {"not-a-jwt"}})
+		require.NoError(t, err)
+		res.Body.Close()
+		statuses = append(statuses, res.StatusCode)
+	}
+
+	report := ReceiveLogout(cfg, 2)
+	require.NoError(t, report.Err)
+	require.Equal(t, []int{http.StatusOK, http.StatusBadRequest}, statuses)
+	require.Len(t, report.LogoutNotifications, 2)
+
+	front := report.LogoutNotifications[0]
+	require.Equal(t, frontChannel, front.Channel)
+	require.Equal(t, "abc", front.Params.Get("sid"))
+	requireCheck(t, front.Checks, "iss", true)
+	requireCheck(t, front.Checks, "sid", true)
+
+	back := report.LogoutNotifications[1]
+	require.Equal(t, backChannel, back.Channel)
+	require.Error(t, back.Err)
+	require.True(t, report.Failed())
+}
+
+func TestReceiveLogoutFailedCheck(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	iss := ""
+	cfg := testConfig(ts)
+	cfg.LogoutReceiverReady = func(_, frontchannelURI string) {
+		res, err := http.Get(frontchannelURI + "?" + url.Values{"iss": {iss}, "sid": {"abc"}}.Encode())
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+
+	iss = cfg.IssuerURL
+	report := ReceiveLogout(cfg, 1)
+	require.False(t, report.Failed(), "%v", report.Err)
+
+	// a notification from another issuer is received but fails its checks
+	iss = "https://elsewhere.test/"
+	report = ReceiveLogout(cfg, 1)
+	require.NoError(t, report.Err)
+	require.Len(t, report.LogoutNotifications, 1)
+	require.NoError(t, report.LogoutNotifications[0].Err)
+	requireCheck(t, report.LogoutNotifications[0].Checks, "iss", false)
+	require.True(t, report.Failed())
+}
+
+func TestCheckLogoutClaims(t *testing.T) {
+	event := map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}}
+	idClaims := map[string]interface{}{"sub": "someone", "sid": "s1"}
+
+	checks := checkLogoutClaims(map[string]interface{}{
+		"events": event, "jti": "1", "sub": "someone", "sid": "s1",
+	}, idClaims, true)
+	for _, name := range []string{"events", "jti", "nonce", "sub", "sid"} {
+		requireCheck(t, checks, name, true)
+	}
+
+	checks = checkLogoutClaims(map[string]interface{}{
+		"events": map[string]interface{}{}, "nonce": "n", "sid": "s2",
+	}, idClaims, true)
+	requireCheck(t, checks, "events", false)
+	requireCheck(t, checks, "jti", false)
+	requireCheck(t, checks, "nonce", false)
+	requireCheck(t, checks, "sid", false)
+
+	checks = checkLogoutClaims(map[string]interface{}{"events": event, "jti": "1"}, nil, false)
+	requireCheck(t, checks, "sub", false)
+
+	checks = checkLogoutClaims(map[string]interface{}{"events": event, "jti": "1", "sub": "someone"}, nil, true)
+	requireCheck(t, checks, "sid", false)
+
+	checks = checkFrontchannelParams(url.Values{}, "https://issuer/", nil, true)
+	requireCheck(t, checks, "iss", false)
+	requireCheck(t, checks, "sid", false)
+}
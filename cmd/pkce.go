@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+// PKCE code challenge methods, https://tools.ietf.org/html/rfc7636#section-4.2
+const (
+	pkceNone  = "none"
+	pkcePlain = "plain"
+	pkceS256  = "S256"
+)
+
+// PKCEReport holds the code verifier and challenge sent for the flow.
+type PKCEReport struct {
+	Method    string
+	Verifier  string
+	Challenge string
+
+	// Supported is the code_challenge_methods_supported advertised by the
+	// provider, nil when the provider did not advertise any.
+	Supported []string
+}
+
+// Advertised reports if the provider listed the method in its discovery document.
+func (p *PKCEReport) Advertised() bool {
+	return contains(p.Supported, p.Method)
+}
+
+func newPKCE(method string, supported []string) (*PKCEReport, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate code verifier: %w", err)
+	}
+
+	p := &PKCEReport{
+		Method:    method,
+		Verifier:  verifier,
+		Supported: supported,
+	}
+
+	switch method {
+	case pkcePlain:
+		p.Challenge = verifier
+	case pkceS256:
+		sum := sha256.Sum256([]byte(verifier))
+		p.Challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+	default:
+		return nil, fmt.Errorf("unsupported PKCE method [%s]", method)
+	}
+
+	return p, nil
+}
+
+func (p *PKCEReport) authOptions() []oauth2.AuthCodeOption {
+	if p == nil {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", p.Challenge),
+		oauth2.SetAuthURLParam("code_challenge_method", p.Method),
+	}
+}
+
+// randomString returns n random bytes encoded as base64url, suitable for
+// use as a code verifier, state or nonce.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
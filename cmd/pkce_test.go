@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestPKCE(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		tamper func(q url.Values)
+		err    string
+	}{
+		{name: "S256", method: "S256"},
+		{name: "plain", method: "plain"},
+		{name: "none", method: "none"},
+		{
+			name:   "wrong verifier",
+			method: "S256",
+			tamper: func(q url.Values) { q.Set("code_challenge", "not-the-challenge") },
+			err:    "code_verifier does not match code_challenge",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(ts)
+			cfg.PKCE = tt.method
+			if tt.tamper != nil {
+				cfg.OpenURL = tamperingBrowser(tt.tamper)
+			}
+
+			report := Test(cfg)
+			params := report.Authorization.Params
+			pkce := report.Authorization.PKCE
+
+			if tt.method == pkceNone {
+				require.Nil(t, pkce)
+				require.NotContains(t, params, "code_challenge")
+				require.NotContains(t, params, "code_challenge_method")
+			} else {
+				require.NotNil(t, pkce)
+				require.True(t, pkce.Advertised())
+				require.Equal(t, []string{tt.method}, params["code_challenge_method"])
+				require.Equal(t, []string{pkce.Challenge}, params["code_challenge"])
+			}
+			if tt.method == pkcePlain {
+				require.Equal(t, pkce.Verifier, pkce.Challenge)
+			}
+
+			if tt.err != "" {
+				require.True(t, report.Failed())
+				require.Error(t, report.Token.Err)
+				require.Contains(t, report.Token.Err.Error(), tt.err)
+				return
+			}
+
+			require.NoError(t, report.Token.Err)
+			require.NotEmpty(t, report.Token.AccessToken)
+		})
+	}
+}
+
+// tamperingBrowser is testmock.OpenURL with the authorization request
+// changed by tamper before it is sent to the provider.
+func tamperingBrowser(tamper func(q url.Values)) func(string) error {
+	return func(login string) error {
+		client := &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		res, err := client.Get(login)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		auth, err := url.Parse(res.Header.Get("Location"))
+		if err != nil {
+			return err
+		}
+
+		q := auth.Query()
+		tamper(q)
+		auth.RawQuery = q.Encode()
+		return testmock.OpenURL(auth.String())
+	}
+}
@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// RefreshReport holds the response to redeeming a refresh token and how
+// the new tokens compare with the originals.
+type RefreshReport struct {
+	Token       *TokenResponseReport
+	IDToken     *TokenReport
+	AccessToken *TokenReport
+
+	// IDTokenChanges and AccessTokenChanges are the claims that differ
+	// from the tokens issued at login, nil when there is nothing to
+	// compare with.
+	IDTokenChanges     []ClaimChange
+	AccessTokenChanges []ClaimChange
+
+	// Rotated is set when a new refresh token was issued.
+	Rotated bool
+
+	// Reuse is the response to redeeming the original refresh token again
+	// after it was rotated and Family the response to then redeeming the
+	// new refresh token. They are only set when refreshReuse is enabled.
+	Reuse  *TokenResponseReport
+	Family *TokenResponseReport
+
+	Checks []Check
+	Err    error
+}
+
+// ClaimChange is a claim that differs between two tokens, Before is nil
+// when the claim was added and After is nil when it was removed.
+type ClaimChange struct {
+	Name   string
+	Before interface{}
+	After  interface{}
+}
+
+var refreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Test refreshing the tokens issued by OIDC server",
+	Long: `Redeems a refresh token and compares the new tokens with those issued at login.
+The output shows whether the refresh token was rotated. With --reuse the original refresh token is redeemed again to check the provider detects the replay and revokes the rotated token.
+Without --refresh-token the login is run first, as the test command does.`,
+	Run: refresh,
+}
+
+var (
+	refreshConfigFile string
+	refreshTimeout    time.Duration
+	refreshToken      string
+	refreshReuse      bool
+)
+
+func init() {
+	f := refreshCmd.Flags()
+	f.StringVarP(&refreshConfigFile, "config", "c", "", "")
+	f.DurationVar(&refreshTimeout, "timeout", 0, "how long to wait for the login to complete, overrides the config")
+	f.StringVar(&refreshToken, "refresh-token", "", "the refresh token to redeem, - reads it from stdin")
+	f.BoolVar(&refreshReuse, "reuse", false, "redeem the original refresh token again to check replay detection, this revokes the tokens")
+	refreshCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(refreshCmd)
+}
+
+func refresh(cmd *cobra.Command, args []string) {
+	runCommand(cmd, refreshConfigFile, refreshTimeout, func(ctx context.Context, cfg TestConfig) *Report {
+		cfg.RefreshReuse = cfg.RefreshReuse || refreshReuse
+
+		token := refreshToken
+		if token == "" {
+			cfg.Refresh = true
+			return TestContext(ctx, cfg)
+		}
+
+		token, err := readToken(token)
+		if err != nil {
+			return &Report{Err: err}
+		}
+
+		return RefreshContext(ctx, cfg, token)
+	})
+}
+
+// Refresh redeems the refresh token, there are no original tokens to
+// compare the new tokens with. Set cfg.Refresh to refresh the tokens
+// at the end of a login instead.
+func Refresh(cfg TestConfig, refreshToken string) *Report {
+	return RefreshContext(context.Background(), cfg, refreshToken)
+}
+
+// RefreshContext is Refresh with a context to cancel the request, it is
+// also cancelled when cfg.Timeout passes.
+func RefreshContext(ctx context.Context, cfg TestConfig, refreshToken string) *Report {
+	return run(ctx, cfg, func(f *flow) {
+		f.refresh(refreshToken)
+	})
+}
+
+// refresh redeems the refresh token and compares the new tokens with those
+// in the report, https://openid.net/specs/openid-connect-core-1_0.html#RefreshTokens
+func (f *flow) refresh(token string) {
+	r := &RefreshReport{}
+	f.report.Refresh = r
+
+	if token == "" {
+		r.Err = errors.New("token response did not contain a refresh_token, the client may need the offline_access scope")
+		return
+	}
+
+	r.Token = f.requestToken(refreshForm(token))
+	r.IDToken, r.AccessToken = f.decodeTokens(r.Token, "")
+	if r.Token.Err != nil {
+		return
+	}
+	if r.Token.IDToken != "" {
+		f.idToken = r.Token.IDToken
+	}
+
+	if original := f.report.IDToken; original != nil && original.Claims != nil {
+		switch {
+		case r.IDToken == nil:
+			r.Checks = append(r.Checks, pass("id_token", "not returned, the original ID token is still used"))
+		case r.IDToken.Claims != nil:
+			r.IDTokenChanges = diffClaims(original.Claims, r.IDToken.Claims)
+			r.IDToken.Checks = append(r.IDToken.Checks, checkRefreshedIDToken(original.Claims, r.IDToken.Claims)...)
+		}
+	}
+
+	if original := f.report.AccessToken; original != nil && original.Claims != nil && r.AccessToken != nil && r.AccessToken.Claims != nil {
+		r.AccessTokenChanges = diffClaims(original.Claims, r.AccessToken.Claims)
+	}
+
+	next := r.Token.RefreshToken
+	r.Rotated = next != "" && next != token
+	r.Checks = append(r.Checks, checkRotation(next, token, r.Token.ClientAuth))
+
+	if !f.cfg.RefreshReuse {
+		return
+	}
+
+	if !r.Rotated {
+		r.Checks = append(r.Checks, pass("reuse", "not tested as the refresh token was not rotated"))
+		return
+	}
+
+	// https://tools.ietf.org/html/draft-ietf-oauth-security-topics-16#section-4.13.2
+	r.Reuse = f.requestToken(refreshForm(token))
+	if r.Reuse.Err == nil {
+		r.Checks = append(r.Checks, fail("reuse", "the original refresh token was accepted again after it was rotated"))
+	} else {
+		r.Checks = append(r.Checks, pass("reuse", "the original refresh token was rejected: %v", r.Reuse.Err))
+	}
+
+	r.Family = f.requestToken(refreshForm(next))
+	if r.Family.Err == nil {
+		r.Checks = append(r.Checks, fail("family", "the rotated refresh token is still accepted after the original was reused, the provider does not revoke the token family"))
+	} else {
+		r.Checks = append(r.Checks, pass("family", "the rotated refresh token was revoked after the original was reused: %v", r.Family.Err))
+	}
+}
+
+func refreshForm(token string) url.Values {
+	return url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token},
+	}
+}
+
+// checkRotation reports if a new refresh token was issued. Public clients
+// can not keep a refresh token safe so they should be rotated,
+// https://tools.ietf.org/html/draft-ietf-oauth-security-topics-16#section-4.13.2
+func checkRotation(next, previous, clientAuth string) Check {
+	const name = "rotation"
+	switch {
+	case next != "" && next != previous:
+		return pass(name, "a new refresh_token was issued")
+	case clientAuth == authNone:
+		return fail(name, "the refresh token was not rotated, public clients should use rotated refresh tokens")
+	case next == "":
+		return pass(name, "no new refresh_token was issued, the original can be used again")
+	default:
+		return pass(name, "the same refresh_token was returned, it is not rotated")
+	}
+}
+
+// checkRefreshedIDToken compares a refreshed ID token with the original,
+// https://openid.net/specs/openid-connect-core-1_0.html#RefreshTokenResponse
+func checkRefreshedIDToken(original, refreshed map[string]interface{}) []Check {
+	var checks []Check
+	for _, claim := range []string{"iss", "sub", "aud", "azp", "auth_time"} {
+		name := "refresh " + claim
+		before, after := original[claim], refreshed[claim]
+		switch {
+		case before == nil && after == nil:
+			continue
+		case reflect.DeepEqual(before, after):
+			checks = append(checks, pass(name, "same as the original ID token"))
+		default:
+			checks = append(checks, fail(name, "[%v] is not the same as the original ID token [%v]", after, before))
+		}
+	}
+
+	before, ok1 := numericDate(original["iat"])
+	after, ok2 := numericDate(refreshed["iat"])
+	if ok1 && ok2 {
+		if after.Before(before) {
+			checks = append(checks, fail("refresh iat", "issued at %s before the original ID token at %s", formatTime(after), formatTime(before)))
+		} else {
+			checks = append(checks, pass("refresh iat", "issued %s after the original ID token", humanDuration(after.Sub(before))))
+		}
+	}
+
+	return checks
+}
+
+// diffClaims lists the claims that were added, removed or changed, sorted
+// by name.
+func diffClaims(before, after map[string]interface{}) []ClaimChange {
+	var changes []ClaimChange
+	for name, b := range before {
+		if a, ok := after[name]; !ok || !reflect.DeepEqual(a, b) {
+			changes = append(changes, ClaimChange{Name: name, Before: b, After: a})
+		}
+	}
+	for name, a := range after {
+		if _, ok := before[name]; !ok {
+			changes = append(changes, ClaimChange{Name: name, After: a})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+func printRefresh(w io.Writer, r *RefreshReport, showSecrets bool) {
+	if r.Token != nil {
+		printTokenResponse(w, r.Token, showSecrets)
+	}
+	printChecks(w, r.Checks)
+	printErr(w, r.Err)
+
+	if r.IDToken != nil {
+		fmt.Fprintln(w, "Refreshed ID token")
+		printToken(w, r.IDToken)
+		printClaimChanges(w, r.IDTokenChanges)
+	}
+
+	if r.AccessToken != nil {
+		fmt.Fprintln(w, "Refreshed access token")
+		printToken(w, r.AccessToken)
+		printClaimChanges(w, r.AccessTokenChanges)
+	}
+
+	if r.Reuse != nil {
+		fmt.Fprintln(w, "Reuse of the original refresh token")
+		printTokenResponse(w, r.Reuse, showSecrets)
+	}
+
+	if r.Family != nil {
+		fmt.Fprintln(w, "Rotated refresh token after reuse")
+		printTokenResponse(w, r.Family, showSecrets)
+	}
+}
+
+func printClaimChanges(w io.Writer, changes []ClaimChange) {
+	if changes == nil {
+		return
+	}
+
+	fmt.Fprintln(w, "  Changed claims:")
+	for _, c := range changes {
+		switch {
+		case c.Before == nil:
+			fmt.Fprintf(w, "    + %s: %v\n", c.Name, c.After)
+		case c.After == nil:
+			fmt.Fprintf(w, "    - %s: %v\n", c.Name, c.Before)
+		default:
+			fmt.Fprintf(w, "    ~ %s: %v => %v\n", c.Name, c.Before, c.After)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestRefresh(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.Refresh = true
+	cfg.RefreshReuse = true
+
+	report := Test(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+
+	r := report.Refresh
+	require.NotNil(t, r)
+	require.NoError(t, r.Token.Err)
+	require.True(t, r.Rotated)
+	requireCheck(t, r.Checks, "rotation", true)
+	requireCheck(t, r.Checks, "reuse", true)
+	requireCheck(t, r.Checks, "family", true)
+	require.Error(t, r.Reuse.Err)
+	require.Error(t, r.Family.Err)
+
+	require.NotNil(t, r.IDToken)
+	requireCheck(t, r.IDToken.Checks, "refresh sub", true)
+	requireCheck(t, r.IDToken.Checks, "refresh aud", true)
+	requireCheck(t, r.IDToken.Checks, "refresh iat", true)
+	require.Contains(t, r.IDTokenChanges, ClaimChange{Name: "nonce", Before: report.Authorization.Nonce})
+}
+
+func TestRefresh(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	login := Test(cfg)
+	require.False(t, login.Failed(), "%v", login.Err)
+
+	report := Refresh(cfg, login.Token.RefreshToken)
+	require.False(t, report.Failed(), "%v", report.Err)
+	require.Nil(t, report.Token)
+	require.NotNil(t, report.Refresh.IDToken)
+	require.Nil(t, report.Refresh.IDTokenChanges)
+
+	report = Refresh(cfg, login.Token.RefreshToken)
+	require.True(t, report.Failed())
+	require.Contains(t, report.Refresh.Token.Err.Error(), "invalid_grant")
+}
+
+func TestCheckRotation(t *testing.T) {
+	require.True(t, checkRotation("new", "old", authSecretBasic).OK)
+	require.True(t, checkRotation("", "old", authSecretBasic).OK)
+	require.True(t, checkRotation("old", "old", authSecretBasic).OK)
+	require.False(t, checkRotation("old", "old", authNone).OK)
+}
+
+func TestDiffClaims(t *testing.T) {
+	before := map[string]interface{}{"sub": "a", "iat": float64(1), "nonce": "n"}
+	after := map[string]interface{}{"sub": "a", "iat": float64(2), "sid": "s"}
+
+	require.Equal(t, []ClaimChange{
+		{Name: "iat", Before: float64(1), After: float64(2)},
+		{Name: "nonce", Before: "n"},
+		{Name: "sid", After: "s"},
+	}, diffClaims(before, after))
+}
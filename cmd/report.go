@@ -0,0 +1,596 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Report describes the outcome of each step of a test run. Steps that were
+// never reached are nil, a step that failed has its Err field set.
+type Report struct {
+	Provider      *ProviderReport
+	TLS           []*TLSReport
+	Authorization *AuthorizationReport
+	Device        *DeviceReport
+	Token         *TokenResponseReport
+	IDToken       *TokenReport
+	AccessToken   *TokenReport
+	UserInfo      *UserInfoReport
+	Introspection []*IntrospectionReport
+	Refresh       *RefreshReport
+	Revocation    *RevocationReport
+	Logout        *LogoutReport
+
+	// LogoutNotifications are the back-channel and front-channel logout
+	// notifications the provider sent to the local server.
+	LogoutNotifications []*LogoutNotificationReport
+
+	// Err holds errors that do not belong to a single step, such as
+	// an invalid config or the local server failing to start.
+	Err error
+}
+
+// Failed reports if any step returned an error or any check failed.
+func (r *Report) Failed() bool {
+	if r.errored() {
+		return true
+	}
+	for _, c := range r.checks() {
+		if !c.OK {
+			return true
+		}
+	}
+	return false
+}
+
+// errored reports if any step returned an error. Steps that inspect the
+// tokens further only run when there is no error, failed checks do not
+// stop them so everything the provider got wrong is reported.
+func (r *Report) errored() bool {
+	switch {
+	case r.Err != nil:
+		return true
+	case r.Provider != nil && r.Provider.Err != nil:
+		return true
+	case r.Authorization != nil && r.Authorization.Err != nil:
+		return true
+	case r.Device != nil && r.Device.Err != nil:
+		return true
+	case r.Token != nil && r.Token.Err != nil:
+		return true
+	case r.UserInfo != nil && r.UserInfo.Err != nil:
+		return true
+	case r.introspectionFailed():
+		return true
+	case r.Revocation != nil && r.Revocation.Err != nil:
+		return true
+	case r.Logout != nil && r.Logout.Err != nil:
+		return true
+	case r.notificationFailed():
+		return true
+	case r.Refresh != nil && (r.Refresh.Err != nil || r.Refresh.Token != nil && r.Refresh.Token.Err != nil):
+		return true
+	default:
+		return false
+	}
+}
+
+// checks returns every check in the report, including those of the tokens
+// decoded along the way.
+func (r *Report) checks() []Check {
+	var checks []Check
+	if a := r.Authorization; a != nil {
+		checks = append(checks, a.Checks...)
+		checks = append(checks, a.JARM.checks()...)
+		checks = append(checks, a.IDToken.checks()...)
+	}
+	if d := r.Device; d != nil {
+		checks = append(checks, d.Checks...)
+	}
+	checks = append(checks, r.Token.checks()...)
+	checks = append(checks, r.IDToken.checks()...)
+	checks = append(checks, r.AccessToken.checks()...)
+	if u := r.UserInfo; u != nil {
+		checks = append(checks, u.Checks...)
+		checks = append(checks, u.Token.checks()...)
+	}
+	for _, i := range r.Introspection {
+		checks = append(checks, i.checks()...)
+	}
+	if rt := r.Refresh; rt != nil {
+		checks = append(checks, rt.Checks...)
+		checks = append(checks, rt.Token.checks()...)
+		checks = append(checks, rt.IDToken.checks()...)
+		checks = append(checks, rt.AccessToken.checks()...)
+	}
+	if rv := r.Revocation; rv != nil {
+		checks = append(checks, rv.Checks...)
+		checks = append(checks, rv.Introspection.checks()...)
+		checks = append(checks, rv.Cascade.checks()...)
+	}
+	if l := r.Logout; l != nil {
+		checks = append(checks, l.Checks...)
+	}
+	for _, n := range r.LogoutNotifications {
+		checks = append(checks, n.Checks...)
+		checks = append(checks, n.LogoutToken.checks()...)
+	}
+	return checks
+}
+
+func (r *Report) introspectionFailed() bool {
+	for _, i := range r.Introspection {
+		if i.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) notificationFailed() bool {
+	for _, n := range r.LogoutNotifications {
+		if n.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ProviderReport holds the endpoints resolved by discovery.
+type ProviderReport struct {
+	AuthURL  string
+	TokenURL string
+
+	// Claims is the raw discovery document returned by the provider.
+	Claims    json.RawMessage
+	ClaimsErr error
+
+	Metadata ProviderMetadata
+
+	JWKS    *jose.JSONWebKeySet
+	JWKSErr error
+
+	Err error
+}
+
+// ProviderMetadata holds the fields from the discovery document that are
+// not exposed by oidc.Provider.
+type ProviderMetadata struct {
+	JWKSURL                       string   `json:"jwks_uri"`
+	IDTokenSigningAlgs            []string `json:"id_token_signing_alg_values_supported"`
+	ResponseModesSupported        []string `json:"response_modes_supported"`
+	AuthorizationSigningAlgs      []string `json:"authorization_signing_alg_values_supported"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+	DeviceAuthorizationEndpoint   string   `json:"device_authorization_endpoint"`
+	UserInfoEndpoint              string   `json:"userinfo_endpoint"`
+	UserInfoSigningAlgs           []string `json:"userinfo_signing_alg_values_supported"`
+	IntrospectionEndpoint         string   `json:"introspection_endpoint"`
+	IntrospectionSigningAlgs      []string `json:"introspection_signing_alg_values_supported"`
+	RevocationEndpoint            string   `json:"revocation_endpoint"`
+	EndSessionEndpoint            string   `json:"end_session_endpoint"`
+
+	// https://openid.net/specs/openid-connect-backchannel-1_0.html#BCSupport and
+	// https://openid.net/specs/openid-connect-frontchannel-1_0.html#OPLogout
+	BackchannelLogoutSupported         bool `json:"backchannel_logout_supported"`
+	BackchannelLogoutSessionSupported  bool `json:"backchannel_logout_session_supported"`
+	FrontchannelLogoutSupported        bool `json:"frontchannel_logout_supported"`
+	FrontchannelLogoutSessionSupported bool `json:"frontchannel_logout_session_supported"`
+
+	TokenEndpointAuthMethods     []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgs []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+
+	// https://tools.ietf.org/html/rfc8705#section-3.3 and section-5
+	TLSClientCertificateBoundAccessTokens bool              `json:"tls_client_certificate_bound_access_tokens"`
+	MTLSEndpointAliases                   map[string]string `json:"mtls_endpoint_aliases"`
+}
+
+// endpoint returns the mTLS alias for the named endpoint when mtls is set
+// and the provider has an alias, otherwise url.
+// https://tools.ietf.org/html/rfc8705#section-5
+func (m ProviderMetadata) endpoint(name, url string, mtls bool) string {
+	if alias := m.MTLSEndpointAliases[name]; mtls && alias != "" {
+		return alias
+	}
+	return url
+}
+
+// AuthorizationReport holds the request sent to the authorization endpoint
+// and the callback the provider redirected back to.
+type AuthorizationReport struct {
+	URL      string
+	Params   url.Values
+	PKCE     *PKCEReport
+	State    string
+	Nonce    string
+	Callback string
+	Response url.Values
+	Checks   []Check
+
+	// ResponseMode is how the response was received, the mode used by
+	// the provider rather than the mode requested.
+	ResponseMode string
+
+	// JARM is the decoded response when a JWT secured response mode was used.
+	JARM *TokenReport
+
+	// Hints suggest how to fix the error returned by the provider.
+	Hints []string
+
+	// IDToken is the ID token returned from the authorization endpoint
+	// in a hybrid flow.
+	IDToken *TokenReport
+
+	Err error
+}
+
+// TokenReport holds a decoded JWT. Nested is the JWT inside a JWE, or a
+// JWS whose payload is itself a token.
+type TokenReport struct {
+	Raw       string
+	Encrypted bool
+	Header    json.RawMessage
+	JOSE      JOSEHeader
+	Payload   json.RawMessage
+	Claims    map[string]interface{}
+	Nested    *TokenReport
+	Checks    []Check
+
+	Signature *SignatureReport
+
+	Err error
+}
+
+// checks returns the token's checks, nil when there is no token.
+func (t *TokenReport) checks() []Check {
+	if t == nil {
+		return nil
+	}
+	return t.Checks
+}
+
+// failed reports if the token, or a token nested in it, could not be decoded.
+func (t *TokenReport) failed() bool {
+	return t.Err != nil || t.Nested != nil && t.Nested.failed()
+}
+
+// Check is the result of a single validation.
+type Check struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+func pass(name, format string, a ...interface{}) Check {
+	return Check{Name: name, OK: true, Detail: fmt.Sprintf(format, a...)}
+}
+
+func fail(name, format string, a ...interface{}) Check {
+	return Check{Name: name, OK: false, Detail: fmt.Sprintf(format, a...)}
+}
+
+// printReport writes the report for a person to read. Tokens in the
+// authorization and token responses are redacted unless showSecrets is set.
+func printReport(w io.Writer, r *Report, showSecrets bool) {
+	if r.Err != nil && r.Provider == nil {
+		fmt.Fprintln(w, "Configuration errors:")
+		fmt.Fprintln(w, r.Err)
+		return
+	}
+
+	if p := r.Provider; p != nil {
+		printProvider(w, p)
+	}
+
+	for _, t := range r.TLS {
+		printTLS(w, t)
+	}
+
+	if a := r.Authorization; a != nil {
+		fmt.Fprintln(w, "Authorization request")
+		fmt.Fprintf(w, "  URL:       %s\n", a.URL)
+		printParams(w, a.Params)
+		printPKCE(w, a.PKCE)
+		fmt.Fprintf(w, "  State:     %s\n", a.State)
+		fmt.Fprintf(w, "  Nonce:     %s\n", a.Nonce)
+		callback, response := a.Callback, a.Response
+		if !showSecrets {
+			callback, response = redactedURL(callback), redactedParams(response)
+		}
+		fmt.Fprintf(w, "  Callback:  %s\n", callback)
+		printParams(w, response)
+		fmt.Fprintf(w, "  Mode:      %s\n", a.ResponseMode)
+		printChecks(w, a.Checks)
+		if a.Err != nil {
+			fmt.Fprintln(w, "  ******************************")
+			fmt.Fprintf(w, "  PROVIDER RETURNED AN ERROR: %v\n", a.Err)
+			for _, h := range a.Hints {
+				fmt.Fprintf(w, "  Hint: %s\n", h)
+			}
+			fmt.Fprintln(w, "  ******************************")
+		}
+
+		if a.JARM != nil {
+			fmt.Fprintln(w, "JWT secured authorization response")
+			printToken(w, a.JARM)
+		}
+
+		if a.IDToken != nil {
+			fmt.Fprintln(w, "ID token from authorization endpoint")
+			printToken(w, a.IDToken)
+		}
+	}
+
+	if d := r.Device; d != nil {
+		fmt.Fprintln(w, "Device authorization")
+		printDevice(w, d, showSecrets)
+	}
+
+	if t := r.Token; t != nil {
+		fmt.Fprintln(w, "Token response")
+		printTokenResponse(w, t, showSecrets)
+	}
+
+	if t := r.IDToken; t != nil {
+		fmt.Fprintln(w, "ID token")
+		printToken(w, t)
+	}
+
+	if t := r.AccessToken; t != nil {
+		fmt.Fprintln(w, "Access token")
+		printToken(w, t)
+	}
+
+	if u := r.UserInfo; u != nil {
+		fmt.Fprintln(w, "UserInfo")
+		printUserInfo(w, u)
+	}
+
+	for _, i := range r.Introspection {
+		fmt.Fprintln(w, "Introspection")
+		printIntrospection(w, i, showSecrets)
+	}
+
+	if rt := r.Refresh; rt != nil {
+		fmt.Fprintln(w, "Refresh")
+		printRefresh(w, rt, showSecrets)
+	}
+
+	if rv := r.Revocation; rv != nil {
+		fmt.Fprintln(w, "Revocation")
+		printRevocation(w, rv, showSecrets)
+	}
+
+	if l := r.Logout; l != nil {
+		fmt.Fprintln(w, "Logout")
+		printLogout(w, l, showSecrets)
+	}
+
+	for _, n := range r.LogoutNotifications {
+		fmt.Fprintf(w, "Logout notification (%s)\n", n.Channel)
+		printLogoutNotification(w, n)
+	}
+
+	if r.Err != nil {
+		fmt.Fprintln(w, r.Err)
+	}
+}
+
+func printTokenResponse(w io.Writer, t *TokenResponseReport, showSecrets bool) {
+	printEndpoint(w, &t.EndpointReport, showSecrets)
+
+	if t.ExpiresIn > 0 {
+		fmt.Fprintf(w, "  Expires in %s at %s\n", t.ExpiresIn, formatTime(time.Now().Add(t.ExpiresIn)))
+	}
+
+	printChecks(w, t.Checks)
+	printErr(w, t.Err)
+}
+
+// printEndpoint writes the request and the response fields, the tokens are
+// redacted unless showSecrets is set. The error is left to the caller.
+func printEndpoint(w io.Writer, e *EndpointReport, showSecrets bool) {
+	fmt.Fprintf(w, "  URL:       %s\n", e.URL)
+	fmt.Fprintf(w, "  Auth:      %s\n", e.ClientAuth)
+	if e.ClientAssertion != nil {
+		fmt.Fprintln(w, "  Client assertion:")
+		printToken(w, e.ClientAssertion)
+	}
+	if e.Status != "" {
+		fmt.Fprintf(w, "  Status:    %s\n", e.Status)
+	}
+
+	if e.Fields != nil {
+		fields := e.Fields
+		if !showSecrets {
+			fields = redactedFields(fields)
+		}
+
+		data, err := json.Marshal(fields)
+		if err != nil {
+			printErr(w, err)
+		} else {
+			printJSON(w, data, "  ")
+		}
+	} else if len(e.Raw) > 0 {
+		fmt.Fprintf(w, "  %s\n", e.Raw)
+	}
+}
+
+func printProvider(w io.Writer, p *ProviderReport) {
+	if p.Err != nil {
+		fmt.Fprintln(w, p.Err)
+		return
+	}
+
+	fmt.Fprintln(w, "Resolved provider endpoint")
+	fmt.Fprintf(w, "  AuthURL:   %s\n", p.AuthURL)
+	fmt.Fprintf(w, "  TokenURL:  %s\n", p.TokenURL)
+
+	if p.ClaimsErr != nil {
+		fmt.Fprintln(w, p.ClaimsErr)
+		return
+	}
+
+	fmt.Fprintln(w, "Claims supported by provider")
+	printJSON(w, p.Claims, "  ")
+
+	fmt.Fprintln(w, "Provider signing keys")
+	if p.JWKSErr != nil {
+		printErr(w, p.JWKSErr)
+		return
+	}
+	for _, k := range p.JWKS.Keys {
+		fmt.Fprintf(w, "  kid: %-20s alg: %-6s use: %-4s thumbprint: %s\n", k.KeyID, k.Algorithm, k.Use, thumbprint(k))
+	}
+}
+
+func printTLS(w io.Writer, t *TLSReport) {
+	fmt.Fprintf(w, "TLS connection to %s\n", t.Host)
+	fmt.Fprintf(w, "  Version:   %s\n", t.Version)
+	if t.ClientCertificate != nil {
+		fmt.Fprintf(w, "  Client certificate requested, sent %s\n", t.ClientCertificate.Subject)
+	}
+	fmt.Fprintln(w, "  Presented certificates:")
+	for i, c := range t.Presented {
+		printCertificate(w, i, c)
+	}
+
+	if t.Chain != nil {
+		source := "system roots"
+		if t.CAPath {
+			source = "caPath"
+		}
+		root := t.Chain[len(t.Chain)-1]
+		fmt.Fprintf(w, "  Verified by root %s from %s\n", root.Subject, source)
+		fmt.Fprintf(w, "    SHA-256: %s\n", fingerprint(root))
+	}
+
+	if t.Err != nil && t.Insecure {
+		fmt.Fprintf(w, "  Verification failed, ignored as insecure is set: %v\n", t.Err)
+	} else {
+		printErr(w, t.Err)
+	}
+}
+
+func printCertificate(w io.Writer, i int, c *x509.Certificate) {
+	fmt.Fprintf(w, "    %d: %s\n", i, c.Subject)
+	fmt.Fprintf(w, "       Issuer:  %s\n", c.Issuer)
+	fmt.Fprintf(w, "       Valid:   %s to %s\n", formatTime(c.NotBefore), formatTime(c.NotAfter))
+
+	names := append([]string(nil), c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) > 0 {
+		fmt.Fprintf(w, "       Names:   %s\n", strings.Join(names, ", "))
+	}
+
+	fmt.Fprintf(w, "       SHA-256: %s\n", fingerprint(c))
+}
+
+// printToken writes the decoded token, followed by the token nested in it
+// when its payload is itself a token.
+func printToken(w io.Writer, t *TokenReport) {
+	if t.Header != nil {
+		fmt.Fprintln(w, "  Header:")
+		printJSON(w, t.Header, "    ")
+	}
+	if t.Nested != nil {
+		fmt.Fprintln(w, "  Nested token:")
+		printToken(w, t.Nested)
+	}
+	if t.Payload != nil {
+		fmt.Fprintln(w, "  Claims:")
+		printJSON(w, t.Payload, "    ")
+	}
+	printSignature(w, t.Signature)
+	printChecks(w, t.Checks)
+	printErr(w, t.Err)
+}
+
+func printSignature(w io.Writer, s *SignatureReport) {
+	if s == nil {
+		return
+	}
+
+	fmt.Fprintln(w, "  Signature:")
+	fmt.Fprintf(w, "    kid:       %s\n", s.KeyID)
+	fmt.Fprintf(w, "    alg:       %s\n", s.Algorithm)
+	if s.Verified {
+		fmt.Fprintf(w, "    Verified with key [%s] thumbprint %s\n", s.MatchedKey, s.Thumbprint)
+	} else {
+		fmt.Fprintf(w, "    NOT VERIFIED: %v\n", s.Err)
+	}
+}
+
+func printChecks(w io.Writer, checks []Check) {
+	for _, c := range checks {
+		result := "PASS"
+		if !c.OK {
+			result = "FAIL"
+		}
+		fmt.Fprintf(w, "  [%s] %s: %s\n", result, c.Name, c.Detail)
+	}
+}
+
+func printParams(w io.Writer, params url.Values) {
+	if len(params) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "  Params:")
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range params[k] {
+			fmt.Fprintf(w, "    %s = %q\n", k, v)
+		}
+	}
+}
+
+func printPKCE(w io.Writer, p *PKCEReport) {
+	if p == nil {
+		return
+	}
+
+	fmt.Fprintln(w, "  PKCE:")
+	fmt.Fprintf(w, "    Method:    %s\n", p.Method)
+	fmt.Fprintf(w, "    Verifier:  %s\n", p.Verifier)
+	fmt.Fprintf(w, "    Challenge: %s\n", p.Challenge)
+
+	switch {
+	case p.Supported == nil:
+		fmt.Fprintln(w, "    Provider did not advertise code_challenge_methods_supported")
+	case p.Advertised():
+		fmt.Fprintf(w, "    Provider supports: %s\n", strings.Join(p.Supported, ", "))
+	default:
+		fmt.Fprintf(w, "    WARNING provider only supports: %s\n", strings.Join(p.Supported, ", "))
+	}
+}
+
+func printJSON(w io.Writer, data []byte, prefix string) {
+	str, err := pp(data, prefix)
+	if err != nil {
+		fmt.Fprintf(w, "%sError formatting JSON: %v\n", prefix, err)
+		return
+	}
+	fmt.Fprintln(w, str)
+}
+
+func printErr(w io.Writer, err error) {
+	if err != nil {
+		fmt.Fprintf(w, "  Error: %v\n", err)
+	}
+}
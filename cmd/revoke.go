@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// RevocationReport holds the response from the revocation endpoint and
+// what happened when the token was used again afterwards.
+type RevocationReport struct {
+	EndpointReport
+
+	TokenTypeHint string
+
+	// Introspection is the revoked token introspected again, or when there
+	// is no introspection endpoint Refresh is the attempt to redeem a
+	// revoked refresh token.
+	Introspection *IntrospectionReport
+	Refresh       *TokenResponseReport
+
+	// Cascade is the access token introspected after the refresh token it
+	// was issued with was revoked.
+	Cascade *IntrospectionReport
+
+	Checks []Check
+}
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Test revoking a token issued by OIDC server",
+	Long: `Revokes a token and then checks the provider no longer accepts it.
+With --access-token that access token is introspected afterwards to check revoking the token revoked it too.
+Without --token the login is run first, as the test command does, and its refresh token is revoked.`,
+	Run: revoke,
+}
+
+var (
+	revokeConfigFile  string
+	revokeTimeout     time.Duration
+	revokeToken       string
+	revokeHint        string
+	revokeAccessToken string
+)
+
+func init() {
+	f := revokeCmd.Flags()
+	f.StringVarP(&revokeConfigFile, "config", "c", "", "")
+	f.DurationVar(&revokeTimeout, "timeout", 0, "how long to wait for the login to complete, overrides the config")
+	f.StringVar(&revokeToken, "token", "", "the token to revoke, - reads it from stdin")
+	f.StringVar(&revokeHint, "hint", "", "the token_type_hint, access_token or refresh_token")
+	f.StringVar(&revokeAccessToken, "access-token", "", "an access token issued with the token, to check it is revoked too")
+	revokeCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(revokeCmd)
+}
+
+func revoke(cmd *cobra.Command, args []string) {
+	runCommand(cmd, revokeConfigFile, revokeTimeout, func(ctx context.Context, cfg TestConfig) *Report {
+		if revokeToken == "" {
+			cfg.Revoke = true
+			return TestContext(ctx, cfg)
+		}
+
+		token, err := readToken(revokeToken)
+		if err != nil {
+			return &Report{Err: err}
+		}
+
+		return RevokeContext(ctx, cfg, token, revokeHint, revokeAccessToken)
+	})
+}
+
+// Revoke revokes the token and checks it is no longer accepted, hint is the
+// optional token_type_hint. When accessToken is not empty it is checked to
+// see if the revocation cascaded to it, as revoking a refresh token should.
+// Set cfg.Revoke to revoke the tokens issued by a login instead.
+func Revoke(cfg TestConfig, token, hint, accessToken string) *Report {
+	return RevokeContext(context.Background(), cfg, token, hint, accessToken)
+}
+
+// RevokeContext is Revoke with a context to cancel the requests, it is
+// also cancelled when cfg.Timeout passes.
+func RevokeContext(ctx context.Context, cfg TestConfig, token, hint, accessToken string) *Report {
+	return run(ctx, cfg, func(f *flow) {
+		f.revoke(token, hint, accessToken)
+	})
+}
+
+// revokeTokens revokes the latest refresh token issued by the flow, or the
+// access token when there is no refresh token.
+func (f *flow) revokeTokens() {
+	access, refresh := f.accessToken, ""
+	if t := f.report.Token; t != nil {
+		refresh = t.RefreshToken
+	}
+	if r := f.report.Refresh; r != nil && r.Token != nil && r.Token.Err == nil {
+		if r.Token.AccessToken != "" {
+			access = r.Token.AccessToken
+		}
+		if r.Token.RefreshToken != "" {
+			refresh = r.Token.RefreshToken
+		}
+	}
+
+	if refresh != "" {
+		f.revoke(refresh, "refresh_token", access)
+	} else if access != "" {
+		f.revoke(access, "access_token", "")
+	}
+}
+
+// revoke posts the token to the revocation endpoint,
+// https://tools.ietf.org/html/rfc7009#section-2.1
+func (f *flow) revoke(token, hint, accessToken string) {
+	r := &RevocationReport{TokenTypeHint: hint}
+	f.report.Revocation = r
+
+	m := f.report.Provider.Metadata
+	if m.RevocationEndpoint == "" {
+		r.Err = errors.New("provider does not advertise a revocation_endpoint")
+		return
+	}
+
+	form := url.Values{"token": {token}}
+	if hint != "" {
+		form.Set("token_type_hint", hint)
+	}
+
+	endpoint := m.endpoint("revocation_endpoint", m.RevocationEndpoint, f.certThumbprint != "")
+	r.EndpointReport = postForm(f.ctx, f.client, &f.cfg, endpoint, form, "application/json")
+	if r.Err != nil {
+		return
+	}
+
+	r.Checks = append(r.Checks, f.verifyRevoked(r, token, hint))
+
+	// Revoking a refresh token should revoke the access tokens from the
+	// same grant, https://tools.ietf.org/html/rfc7009#section-2.1
+	if accessToken != "" && accessToken != token {
+		if m.IntrospectionEndpoint == "" {
+			r.Checks = append(r.Checks, pass("cascade", "not checked as the provider does not advertise an introspection_endpoint"))
+		} else {
+			r.Cascade = f.introspect(accessToken, "access_token")
+			r.Checks = append(r.Checks, checkInactive("cascade", "the access token", r.Cascade))
+		}
+	}
+}
+
+// verifyRevoked introspects the revoked token, or when there is no
+// introspection endpoint tries to redeem a revoked refresh token.
+func (f *flow) verifyRevoked(r *RevocationReport, token, hint string) Check {
+	const name = "revoked"
+	switch {
+	case f.report.Provider.Metadata.IntrospectionEndpoint != "":
+		r.Introspection = f.introspect(token, hint)
+		return checkInactive(name, "the token", r.Introspection)
+	case hint == "refresh_token":
+		r.Refresh = f.requestToken(refreshForm(token))
+		if r.Refresh.Err == nil {
+			return fail(name, "the refresh token was redeemed after it was revoked")
+		}
+		return pass(name, "the refresh token was rejected: %v", r.Refresh.Err)
+	default:
+		return pass(name, "not verified as the provider does not advertise an introspection_endpoint")
+	}
+}
+
+// checkInactive reports if the introspection found the token was no longer
+// active, what describes the token.
+func checkInactive(name, what string, i *IntrospectionReport) Check {
+	switch {
+	case i.Err != nil:
+		return fail(name, "could not introspect %s: %v", what, i.Err)
+	case i.Active:
+		return fail(name, "%s is still active", what)
+	default:
+		return pass(name, "%s is no longer active", what)
+	}
+}
+
+func printRevocation(w io.Writer, r *RevocationReport, showSecrets bool) {
+	if r.URL != "" {
+		printEndpoint(w, &r.EndpointReport, showSecrets)
+	}
+	if r.TokenTypeHint != "" {
+		fmt.Fprintf(w, "  Hint:      %s\n", r.TokenTypeHint)
+	}
+	printChecks(w, r.Checks)
+	printErr(w, r.Err)
+
+	if r.Introspection != nil {
+		fmt.Fprintln(w, "Introspection of the revoked token")
+		printIntrospection(w, r.Introspection, showSecrets)
+	}
+
+	if r.Refresh != nil {
+		fmt.Fprintln(w, "Refresh with the revoked token")
+		printTokenResponse(w, r.Refresh, showSecrets)
+	}
+
+	if r.Cascade != nil {
+		fmt.Fprintln(w, "Introspection of the access token")
+		printIntrospection(w, r.Cascade, showSecrets)
+	}
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestRevoke(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.Refresh = true
+	cfg.Revoke = true
+
+	report := Test(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+
+	r := report.Revocation
+	require.NotNil(t, r)
+	require.Equal(t, ts.URL+"/oauth2/revoke", r.URL)
+	require.Equal(t, "refresh_token", r.TokenTypeHint)
+	requireCheck(t, r.Checks, "revoked", true)
+	requireCheck(t, r.Checks, "cascade", true)
+	require.False(t, r.Introspection.Active)
+	require.False(t, r.Cascade.Active)
+
+	refreshed := Refresh(cfg, report.Refresh.Token.RefreshToken)
+	require.True(t, refreshed.Failed())
+}
+
+func TestRevoke(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	login := Test(cfg)
+	require.False(t, login.Failed(), "%v", login.Err)
+
+	report := Revoke(cfg, login.Token.AccessToken, "access_token", "")
+	require.False(t, report.Failed(), "%v", report.Err)
+	requireCheck(t, report.Revocation.Checks, "revoked", true)
+	require.Nil(t, report.Revocation.Cascade)
+
+	// the refresh token is still valid after the access token is revoked
+	report = Introspect(cfg, login.Token.RefreshToken, "refresh_token")
+	require.False(t, report.Failed(), "%v", report.Err)
+	require.True(t, report.Introspection[0].Active)
+
+	// the cascade is checked without a hint, and fails when the access
+	// token from another login is still active
+	other := Test(cfg)
+	require.False(t, other.Failed(), "%v", other.Err)
+	report = Revoke(cfg, login.Token.RefreshToken, "", other.Token.AccessToken)
+	requireCheck(t, report.Revocation.Checks, "revoked", true)
+	requireCheck(t, report.Revocation.Checks, "cascade", false)
+	require.True(t, report.Revocation.Cascade.Active)
+	require.True(t, report.Failed())
+
+	cfg.ClientID = "another"
+	report = Revoke(cfg, other.Token.RefreshToken, "refresh_token", "")
+	require.True(t, report.Failed())
+	require.Contains(t, report.Revocation.Err.Error(), "unauthorized_client")
+}
+
+func TestCheckInactive(t *testing.T) {
+	require.True(t, checkInactive("revoked", "the token", &IntrospectionReport{}).OK)
+	require.False(t, checkInactive("revoked", "the token", &IntrospectionReport{Active: true}).OK)
+	require.False(t, checkInactive("revoked", "the token", &IntrospectionReport{EndpointReport: EndpointReport{Err: errors.New("failed")}}).OK)
+}
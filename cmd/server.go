@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+// handler returns the mux for the local server the browser is sent to
+// to start the login or logout and that the provider redirects back to
+// and sends logout notifications to.
+func (f *flow) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", f.handleLogin)
+	mux.HandleFunc(f.cfg.callbackPath(), f.handleCallback)
+	mux.HandleFunc("/fragment", f.handleFragment)
+	mux.HandleFunc("/logout", f.handleLogout)
+	mux.HandleFunc(f.cfg.logoutCallbackPath(), f.handleLogoutCallback)
+	mux.HandleFunc("/backchannel-logout", f.handleBackchannelLogout)
+	mux.HandleFunc("/frontchannel-logout", f.handleFrontchannelLogout)
+	return mux
+}
+
+func (f *flow) handleLogin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+	case http.MethodGet:
+		cfg := f.cfg
+		opts := append(f.pkce.authOptions(), oidc.Nonce(f.nonce))
+		if rt := cfg.responseType(); rt != "code" {
+			opts = append(opts, oauth2.SetAuthURLParam("response_type", rt))
+		}
+		if cfg.ResponseMode != "" {
+			opts = append(opts, oauth2.SetAuthURLParam("response_mode", cfg.ResponseMode))
+		}
+
+		authURL, params := authCodeURL(f.oauth2, f.state, cfg.ExtraParams, opts...)
+
+		f.mu.Lock()
+		f.report.Authorization = f.newAuthorization()
+		f.report.Authorization.URL = authURL
+		f.report.Authorization.Params = params
+		f.mu.Unlock()
+
+		http.Redirect(w, r, authURL, http.StatusFound)
+
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *flow) handleCallback(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+	case http.MethodGet:
+		if r.URL.RawQuery == "" {
+			// The response may be in the fragment which is never sent
+			// to the server, the page posts it back to /fragment.
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(fragmentPage))
+			return
+		}
+
+		f.complete(w, r.URL.String(), "query", r.URL.Query())
+	case http.MethodPost:
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		f.complete(w, "POST "+r.URL.Path+" "+r.PostForm.Encode(), "form_post", r.PostForm)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *flow) handleFragment(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		f.complete(w, f.cfg.callbackPath()+"#"+r.PostForm.Encode(), "fragment", r.PostForm)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// complete handles the first response from the provider and signals the
+// flow is done. Any later responses, such as the user refreshing the page,
+// are rejected so they do not overwrite the report.
+func (f *flow) complete(w http.ResponseWriter, callback, mode string, response url.Values) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	select {
+	case <-f.done:
+		http.Error(w, "oidcdebug has already received a response from the provider", http.StatusConflict)
+		return
+	default:
+	}
+
+	f.handleResponse(callback, mode, response)
+	writeResultPage(w, f.report)
+	close(f.done)
+}
+
+// fragmentPage is served on the callback when there is no query string.
+// Implicit and hybrid flows return the response in the URL fragment, which
+// the browser never sends to the server, so the script posts it back.
+const fragmentPage = `<!DOCTYPE html>
+<html>
+<head><title>oidcdebug</title></head>
+<body>
+<p id="result">Sending response to oidcdebug...</p>
+<script>
+var result = document.getElementById("result");
+var body = window.location.hash.substring(1);
+history.replaceState(null, "", window.location.pathname);
+fetch("/fragment", {
+  method: "POST",
+  headers: {"Content-Type": "application/x-www-form-urlencoded"},
+  body: body
+}).then(function (res) {
+  return res.text();
+}).then(function (html) {
+  document.open();
+  document.write(html);
+  document.close();
+}).catch(function (err) {
+  result.textContent = "Error sending response to oidcdebug: " + err;
+});
+</script>
+</body>
+</html>
+`
+
+var resultPage = template.Must(template.New("result").Parse(`<!DOCTYPE html>
+<html>
+<head><title>oidcdebug - {{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{range .Hints}}<p>Hint: {{.}}</p>
+{{end}}</body>
+</html>
+`))
+
+// writeResultPage tells the user in the browser how the login went.
+func writeResultPage(w http.ResponseWriter, report *Report) {
+	if a := report.Authorization; a != nil && a.Err != nil {
+		writePage(w, "Login failed", a.Err.Error(), a.Hints)
+	} else if report.Failed() {
+		writePage(w, "Login failed", "Check the oidcdebug output for details.", nil)
+	} else {
+		writePage(w, "Login complete", "You can close this window and return to oidcdebug.", nil)
+	}
+}
+
+func writePage(w http.ResponseWriter, title, message string, hints []string) {
+	data := struct {
+		Title   string
+		Message string
+		Hints   []string
+	}{title, message, hints}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	resultPage.Execute(w, data)
+}
@@ -3,16 +3,18 @@ package cmd
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -58,14 +60,219 @@ type TestConfig struct {
 	IssuerURL string `yaml:"issuerURL"`
 	Insecure  bool   `yaml:"insecure"`
 
-	Scopes      []string `yaml:"scopes"`
-	ExtraParams extra    `yaml:"extraParams"`
+	// CAPath is a PEM file or a directory of PEM files with extra roots to
+	// trust, they are added to the system roots unless CAOnly is set.
+	CAPath string `yaml:"caPath,omitempty"`
+	CAOnly bool   `yaml:"caOnly,omitempty"`
+
+	// ClientCert and ClientKey are PEM files with the certificate and key
+	// for mutual TLS, https://tools.ietf.org/html/rfc8705
+	ClientCert string `yaml:"clientCert,omitempty"`
+	ClientKey  string `yaml:"clientKey,omitempty"`
+
+	Scopes []string `yaml:"scopes"`
+
+	// ExtraParams are added to the authorization URL and to the token
+	// request, such as resource or audience to choose the API.
+	ExtraParams extra `yaml:"extraParams"`
 
 	ClientID     string `yaml:"clientID"`
 	ClientSecret string `yaml:"clientSecret"`
 	ClientPort   int    `yaml:"clientPort"`
 
+	// ClientAuth is how the client authenticates to the token endpoint,
+	// client_secret_basic, client_secret_post, client_secret_jwt,
+	// private_key_jwt, tls_client_auth, self_signed_tls_client_auth or
+	// none. Defaults to client_secret_basic when there is a clientSecret.
+	ClientAuth string `yaml:"clientAuth,omitempty"`
+
+	// ClientAssertionKey is a PEM or JWK file with the private key for
+	// private_key_jwt. ClientAssertionKeyID and ClientAssertionAlg override
+	// the kid and alg of the assertion.
+	ClientAssertionKey   string `yaml:"clientAssertionKey,omitempty"`
+	ClientAssertionKeyID string `yaml:"clientAssertionKeyID,omitempty"`
+	ClientAssertionAlg   string `yaml:"clientAssertionAlg,omitempty"`
+
+	// RedirectHost is the host used in the redirect URI, localhost,
+	// 127.0.0.1 or [::1]. Defaults to localhost. Set ClientPort to 0 to
+	// listen on any free port, https://tools.ietf.org/html/rfc8252#section-7.3
+	RedirectHost string `yaml:"redirectHost,omitempty"`
+
+	// CallbackPath is the path of the redirect URI, defaults to /callback.
+	CallbackPath string `yaml:"callbackPath,omitempty"`
+
+	// ResponseType is the response_type to request, such as code,
+	// id_token or "code id_token". Defaults to code.
+	ResponseType string `yaml:"responseType,omitempty"`
+
+	// ResponseMode is the response_mode to request, query, fragment,
+	// form_post or one of the JARM modes jwt, query.jwt, fragment.jwt or
+	// form_post.jwt. When empty no response_mode is sent.
+	ResponseMode string `yaml:"responseMode,omitempty"`
+
+	// PKCE is the code challenge method to use, S256, plain or none.
+	PKCE string `yaml:"pkce,omitempty"`
+
+	// SkipUserInfo stops the UserInfo endpoint being called once the
+	// login completes.
+	SkipUserInfo bool `yaml:"skipUserInfo,omitempty"`
+
+	// DecryptionKey is a PEM or JWK file with the private key to decrypt
+	// encrypted responses, such as an encrypted UserInfo response.
+	DecryptionKey string `yaml:"decryptionKey,omitempty"`
+
+	// Introspect posts the access and refresh tokens to the introspection
+	// endpoint once the login completes. IntrospectJWT asks for a signed
+	// JWT response, https://www.rfc-editor.org/rfc/rfc9701
+	Introspect    bool `yaml:"introspect,omitempty"`
+	IntrospectJWT bool `yaml:"introspectJWT,omitempty"`
+
+	// Refresh redeems the refresh token once the login completes and
+	// compares the new tokens with the originals. RefreshReuse then redeems
+	// the original refresh token again to check the provider detects the
+	// replay, this revokes the tokens.
+	Refresh      bool `yaml:"refresh,omitempty"`
+	RefreshReuse bool `yaml:"refreshReuse,omitempty"`
+
+	// Logout signs out at the provider's end_session_endpoint at the end
+	// of the login, LogoutCallbackPath is the path of the
+	// post_logout_redirect_uri and defaults to /logout/callback.
+	Logout             bool   `yaml:"logout,omitempty"`
+	LogoutCallbackPath string `yaml:"logoutCallbackPath,omitempty"`
+
+	// Revoke revokes the refresh token, or the access token when there is
+	// no refresh token, at the end of the login and checks the provider no
+	// longer accepts it.
+	Revoke bool `yaml:"revoke,omitempty"`
+
+	// ShowUnverified displays the claims of tokens that fail signature
+	// verification, by default they are hidden.
+	ShowUnverified bool `yaml:"showUnverified,omitempty"`
+
+	// ShowSecrets displays tokens in the token response, by default
+	// they are redacted.
+	ShowSecrets bool `yaml:"showSecrets,omitempty"`
+
+	// Timeout is how long to wait for the login to complete,
+	// zero waits forever.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// ClockSkew is the allowance when checking exp, nbf and iat.
+	ClockSkew time.Duration `yaml:"clockSkew,omitempty"`
+
 	OpenURL func(url string) error
+
+	// DevicePrompt is called with the device authorization response so
+	// the user can be told the user code, before polling for the tokens.
+	DevicePrompt func(d *DeviceReport) `yaml:"-"`
+
+	// LogoutReceiverReady is called with the URIs logout notifications are
+	// received on once the local server is listening for them.
+	LogoutReceiverReady func(backchannelURI, frontchannelURI string) `yaml:"-"`
+}
+
+// scopes returns the configured scopes, defaulting to just "openid" which
+// is required for OpenID Connect flows.
+func (cfg *TestConfig) scopes() []string {
+	if len(cfg.Scopes) == 0 {
+		return []string{oidc.ScopeOpenID}
+	}
+	return cfg.Scopes
+}
+
+// requestedAudience returns the resource and audience extra params, used
+// by providers to choose the audience of the access token.
+func (cfg *TestConfig) requestedAudience() []string {
+	return append(append([]string(nil), cfg.ExtraParams["resource"]...), cfg.ExtraParams["audience"]...)
+}
+
+// addExtraParams adds the extraParams to a form posted to the token or
+// device authorization endpoint, the params set by the grant take precedence.
+func (cfg *TestConfig) addExtraParams(form url.Values) {
+	for k, v := range cfg.ExtraParams {
+		if _, ok := form[k]; !ok {
+			form[k] = v
+		}
+	}
+}
+
+// responseType returns the configured response type, defaulting to the
+// authorization code flow.
+func (cfg *TestConfig) responseType() string {
+	if cfg.ResponseType == "" {
+		return "code"
+	}
+	return cfg.ResponseType
+}
+
+// responseMode returns the response mode the provider should use, either
+// the configured mode or the default for the response type.
+func (cfg *TestConfig) responseMode() string {
+	def := "fragment"
+	if cfg.responseType() == "code" {
+		def = "query"
+	}
+
+	switch cfg.ResponseMode {
+	case "":
+		return def
+	case "jwt":
+		return def + ".jwt"
+	default:
+		return cfg.ResponseMode
+	}
+}
+
+// redirectHost returns the host for the redirect URI, defaulting to localhost.
+// The IPv6 loopback is accepted with or without brackets.
+func (cfg *TestConfig) redirectHost() string {
+	switch cfg.RedirectHost {
+	case "":
+		return "localhost"
+	case "::1":
+		return "[::1]"
+	default:
+		return cfg.RedirectHost
+	}
+}
+
+// callbackPath returns the path of the redirect URI, defaulting to /callback.
+func (cfg *TestConfig) callbackPath() string {
+	if cfg.CallbackPath == "" {
+		return "/callback"
+	}
+	return cfg.CallbackPath
+}
+
+// logoutCallbackPath returns the path of the post logout redirect URI,
+// defaulting to /logout/callback.
+func (cfg *TestConfig) logoutCallbackPath() string {
+	if cfg.LogoutCallbackPath == "" {
+		return "/logout/callback"
+	}
+	return cfg.LogoutCallbackPath
+}
+
+// serverPaths are the fixed routes of the local server.
+var serverPaths = []string{"/login", "/fragment", "/logout", "/backchannel-logout", "/frontchannel-logout"}
+
+// invalidServerPath returns why a configured path can not be used by the
+// local server, or an empty string when it can. On the mux a path ending
+// in / matches every path below it, and / every path without a route of
+// its own, which would shadow the fixed routes.
+func invalidServerPath(p string) string {
+	switch {
+	case !strings.HasPrefix(p, "/") || strings.ContainsAny(p, "?#"):
+		return "expected a path starting with /"
+	case strings.HasSuffix(p, "/"):
+		return "it must not end with / as it would also match the paths below it"
+	case p != path.Clean(p):
+		return "expected a clean path without empty, . or .. segments"
+	case contains(serverPaths, p):
+		return "it is used by oidcdebug"
+	default:
+		return ""
+	}
 }
 
 func (cfg *TestConfig) validate() error {
@@ -79,10 +286,63 @@ func (cfg *TestConfig) validate() error {
 		err = append(err, "clientID is required")
 	}
 
-	if cfg.ClientPort < 1 || cfg.ClientPort > math.MaxUint16 {
+	if cfg.ClientPort < 0 || cfg.ClientPort > math.MaxUint16 {
 		err = append(err, fmt.Sprintf("clientPort [%d] is invalid", cfg.ClientPort))
 	}
 
+	switch cfg.redirectHost() {
+	case "localhost", "127.0.0.1", "[::1]":
+	default:
+		err = append(err, fmt.Sprintf("redirectHost [%s] is invalid, expected localhost, 127.0.0.1 or [::1]", cfg.RedirectHost))
+	}
+
+	if reason := invalidServerPath(cfg.callbackPath()); reason != "" {
+		err = append(err, fmt.Sprintf("callbackPath [%s] is invalid, %s", cfg.CallbackPath, reason))
+	}
+
+	if reason := invalidServerPath(cfg.logoutCallbackPath()); reason != "" {
+		err = append(err, fmt.Sprintf("logoutCallbackPath [%s] is invalid, %s", cfg.LogoutCallbackPath, reason))
+	} else if cfg.logoutCallbackPath() == cfg.callbackPath() {
+		err = append(err, fmt.Sprintf("logoutCallbackPath [%s] is invalid, it is the same as callbackPath", cfg.logoutCallbackPath()))
+	}
+
+	seen := map[string]bool{}
+	for _, t := range strings.Fields(cfg.responseType()) {
+		switch {
+		case t != "code" && t != "id_token" && t != "token":
+			err = append(err, fmt.Sprintf("responseType [%s] is invalid, [%s] is not one of code, id_token or token", cfg.ResponseType, t))
+		case seen[t]:
+			err = append(err, fmt.Sprintf("responseType [%s] is invalid, [%s] is repeated", cfg.ResponseType, t))
+		}
+		seen[t] = true
+	}
+
+	switch cfg.ResponseMode {
+	case "", "query", "fragment", "form_post", "jwt", "query.jwt", "fragment.jwt", "form_post.jwt":
+	default:
+		err = append(err, fmt.Sprintf("responseMode [%s] is invalid", cfg.ResponseMode))
+	}
+
+	switch cfg.PKCE {
+	case "", pkceNone, pkcePlain, pkceS256:
+	default:
+		err = append(err, fmt.Sprintf("pkce [%s] is invalid, expected S256, plain or none", cfg.PKCE))
+	}
+
+	err = append(err, cfg.validateClientAuth()...)
+
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		err = append(err, "clientCert and clientKey must be set together")
+	}
+
+	if cfg.Timeout < 0 {
+		err = append(err, fmt.Sprintf("timeout [%s] can not be negative", cfg.Timeout))
+	}
+
+	if cfg.ClockSkew < 0 {
+		err = append(err, fmt.Sprintf("clockSkew [%s] can not be negative", cfg.ClockSkew))
+	}
+
 	if len(err) > 0 {
 		return fmt.Errorf("config errors:\n  %s", strings.Join(err, "\n  "))
 	}
@@ -97,156 +357,308 @@ var testCmd = &cobra.Command{
 	Run:   test,
 }
 
-var testConfigFile string
+var (
+	testConfigFile string
+	testTimeout    time.Duration
+)
 
 func init() {
 	f := testCmd.Flags()
 	f.StringVarP(&testConfigFile, "config", "c", "", "")
+	f.DurationVar(&testTimeout, "timeout", 0, "how long to wait for the login to complete, overrides the config")
 	testCmd.MarkFlagRequired("config")
 	rootCmd.AddCommand(testCmd)
 }
 
 func test(cmd *cobra.Command, args []string) {
-	cfg, err := loadConfig(testConfigFile)
+	runCommand(cmd, testConfigFile, testTimeout, TestContext)
+}
+
+// runCommand loads and displays the config, runs the flow and prints the
+// report. The process exits with an error status when the config could not
+// be loaded or a step or check failed.
+func runCommand(cmd *cobra.Command, configFile string, timeout time.Duration, run func(context.Context, TestConfig) *Report) {
+	cfg, err := loadConfig(configFile)
 	if err != nil {
 		fmt.Printf("Error reading config: %v\n", err)
-		return
+		os.Exit(1)
+	}
+
+	if cmd.Flags().Changed("timeout") {
+		cfg.Timeout = timeout
 	}
 
 	fmt.Println("The config is")
 	str, err := yp(cfg, "  | ")
 	if err != nil {
-		fmt.Printf("  Error displaying config: %v", err)
-		return
+		fmt.Printf("  Error displaying config: %v\n", err)
+		os.Exit(1)
 	}
 	fmt.Println(str)
 
-	Test(cfg)
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	report := run(ctx, cfg)
+	printReport(os.Stdout, report, cfg.ShowSecrets)
+
+	if report.Failed() {
+		os.Exit(1)
+	}
 }
 
-func Test(cfg TestConfig) {
+// readToken returns the token from a flag, - reads the token from stdin.
+func readToken(token string) (string, error) {
+	if token != "-" {
+		return token, nil
+	}
+
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("error reading token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Test runs an authorization code flow against the configured provider and
+// reports what happened at each step. A failed step records its error in the
+// report, later steps are left nil.
+func Test(cfg TestConfig) *Report {
+	return TestContext(context.Background(), cfg)
+}
+
+// TestContext is Test with a context to cancel the flow, the flow is
+// also cancelled when cfg.Timeout passes.
+func TestContext(ctx context.Context, cfg TestConfig) *Report {
+	return run(ctx, cfg, (*flow).browserLogin)
+}
+
+// run validates the config and discovers the provider, the steps shared by
+// every grant, and then hands the flow to grant. The report is returned
+// without calling grant when any of the shared steps fail. After the grant
+// the UserInfo endpoint is called and, when cfg.Introspect, cfg.Refresh,
+// cfg.Revoke and cfg.Logout are set, the tokens are introspected, refreshed
+// and revoked and then the user is logged out.
+func run(ctx context.Context, cfg TestConfig, grant func(*flow)) *Report {
+	report := &Report{}
+
 	err := cfg.validate()
 	if err != nil {
-		fmt.Println("Configuration errors:")
-		fmt.Println(err)
-		return
+		report.Err = err
+		return report
 	}
 
-	clientURL := url.URL{
-		Scheme: "http",
-		Host:   fmt.Sprintf("localhost:%d", cfg.ClientPort),
-		Path:   "/",
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	verifier, err := newTLSVerifier(cfg)
+	if err != nil {
+		report.Err = err
+		return report
 	}
+	defer func() {
+		report.TLS = verifier.results()
+	}()
+
+	client := client(verifier)
+	ctx = oidc.ClientContext(ctx, client)
 
-	client := client(cfg)
-	ctx := oidc.ClientContext(context.Background(), client)
+	report.Provider = &ProviderReport{}
 	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
 	if err != nil {
-		fmt.Printf("Error fetching provider: %v", err)
-		return
+		report.Provider.Err = fmt.Errorf("error fetching provider: %w", err)
+		return report
 	}
 
-	showProvider(provider)
+	readProvider(report.Provider, provider)
+	report.Provider.JWKS, report.Provider.JWKSErr = fetchJWKS(ctx, client, report.Provider.Metadata.JWKSURL)
+
+	f := &flow{
+		ctx:    ctx,
+		cfg:    cfg,
+		client: client,
+		report: report,
+		done:   make(chan struct{}),
+
+		certThumbprint: verifier.clientThumbprint(),
+	}
 
 	// Configure an OpenID Connect aware OAuth2 client.
-	oauth2Config := &oauth2.Config{
-		ClientID:     "test",
-		ClientSecret: "123456",
-		RedirectURL:  clientURL.ResolveReference(&url.URL{Path: "callback"}).String(),
+	f.oauth2 = &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
 
 		// Discovery returns the OAuth2 endpoints.
 		Endpoint: provider.Endpoint(),
 
-		// "openid" is a required scope for OpenID Connect flows.
-		Scopes: []string{oidc.ScopeOpenID},
+		Scopes: cfg.scopes(),
+	}
+
+	grant(f)
+
+	if !cfg.SkipUserInfo && f.accessToken != "" && report.Provider.Metadata.UserInfoEndpoint != "" &&
+		contains(f.oauth2.Scopes, oidc.ScopeOpenID) && !report.errored() {
+		f.userInfo(f.accessToken)
+	}
+
+	if cfg.Introspect && report.Introspection == nil && !report.errored() {
+		f.introspectTokens()
+	}
+
+	if cfg.Refresh && report.Refresh == nil && report.Token != nil && !report.errored() {
+		f.refresh(report.Token.RefreshToken)
+	}
+
+	if cfg.Revoke && report.Revocation == nil && !report.errored() {
+		f.revokeTokens()
 	}
 
-	done := make(chan error, 1)
+	if cfg.Logout && report.Logout == nil && !report.errored() {
+		f.logout(f.idToken)
+	}
 
-	http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Printf("Received %s %s\n", r.Method, r.URL.Path)
+	return report
+}
 
-		switch r.Method {
-		case http.MethodHead:
-		case http.MethodGet:
-			authURL := oauth2Config.AuthCodeURL("no-csrf-here", oauth2.AccessTypeOnline)
-			fmt.Printf("Redirecting client to %s\n", authURL)
-			http.Redirect(w, r, authURL, http.StatusFound)
+// browserLogin sends the browser to the local server which starts the
+// authorization request and receives the provider's response.
+func (f *flow) browserLogin() {
+	cfg, report := f.cfg, f.report
 
-		default:
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	var err error
+	if cfg.PKCE != "" && cfg.PKCE != pkceNone {
+		f.pkce, err = newPKCE(cfg.PKCE, report.Provider.Metadata.CodeChallengeMethodsSupported)
+		if err != nil {
+			report.Err = err
+			return
 		}
+	}
+
+	f.state, err = randomString(16)
+	if err != nil {
+		report.Err = fmt.Errorf("could not generate state: %w", err)
+		return
+	}
+
+	f.nonce, err = randomString(16)
+	if err != nil {
+		report.Err = fmt.Errorf("could not generate nonce: %w", err)
+		return
+	}
+
+	err = f.openBrowser("login", f.done, "the login to complete", func(base *url.URL) {
+		f.oauth2.RedirectURL = base.ResolveReference(&url.URL{Path: cfg.callbackPath()}).String()
 	})
+	if err != nil {
+		report.Err = err
+	}
+}
 
-	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Printf("Received %s %s\n", r.Method, r.URL.Path)
-
-		switch r.Method {
-		case http.MethodHead:
-		case http.MethodGet:
-			q := r.URL.Query()
-
-			code := q.Get("code")
-			if code != "" {
-				showCode(ctx, oauth2Config, code)
-			}
-
-			idToken := q.Get("id_token")
-			if idToken != "" {
-				showToken(idToken)
-			}
-
-			token := q.Get("token")
-			if token != "" {
-				showToken(token)
-			}
-
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("done"))
-
-			// Need to improve this handling, race condition between sending
-			// the response to the browser and the app terminating.
-			// Also could cause the ListenAndServe routine to panic if it
-			// terminates with an error.
-			close(done)
-		default:
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+// openBrowser starts the local server and sends the browser to path on it,
+// then waits until done is closed. ready is called with the server's URL
+// before the browser is opened, waitingFor describes what done means when
+// the wait is cancelled.
+func (f *flow) openBrowser(path string, done <-chan struct{}, waitingFor string, ready func(base *url.URL)) error {
+	return f.serveLocal(done, waitingFor, func(base *url.URL) error {
+		ready(base)
+
+		openURL := base.ResolveReference(&url.URL{Path: path}).String()
+		var err error
+		if f.cfg.OpenURL == nil {
+			err = browser.OpenURL(openURL)
+		} else {
+			err = f.cfg.OpenURL(openURL)
 		}
+		if err != nil {
+			return fmt.Errorf("error opening URL: %w", err)
+		}
+		return nil
 	})
+}
 
+// serveLocal starts the local server and waits until done is closed. start
+// is called with the server's URL once it is listening, waiting stops if
+// start returns an error.
+func (f *flow) serveLocal(done <-chan struct{}, waitingFor string, start func(base *url.URL) error) error {
+	cfg := f.cfg
+
+	// Listen before opening the browser so the request can not arrive
+	// before the server is ready to accept it. The port is read back
+	// from the listener as it is chosen by the OS when ClientPort is 0.
+	host := strings.Trim(cfg.redirectHost(), "[]")
+	addr := net.JoinHostPort(host, strconv.Itoa(cfg.ClientPort))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error starting local server on %s, set clientPort to 0 to use any free port: %w", addr, err)
+	}
+
+	base := &url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(host, strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)),
+		Path:   "/",
+	}
+
+	server := &http.Server{Handler: f.handler()}
+	serveErr := make(chan error, 1)
 	go func() {
-		err := http.ListenAndServe(clientURL.Host, nil)
-		if err != nil {
-			done <- err
-		}
-		// currently this routine only terminates if there's an error
-		// from ListenAndServe
-		close(done)
+		serveErr <- server.Serve(listener)
 	}()
 
-	loginURL := clientURL.ResolveReference(&url.URL{Path: "login"}).String()
-	if cfg.OpenURL == nil {
-		err = browser.OpenURL(loginURL)
-	} else {
-		err = cfg.OpenURL(loginURL)
+	err = start(base)
+	if err == nil {
+		select {
+		case <-done:
+		case serveErr := <-serveErr:
+			err = fmt.Errorf("local server stopped: %w", serveErr)
+		case <-f.ctx.Done():
+			err = f.cancelled(waitingFor)
+		}
 	}
 
+	// Shutdown waits for the handlers to finish, so the browser gets its
+	// response and the report is not modified once it has been returned.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+
+	return err
+}
+
+// authCodeURL builds the URL for the authorization endpoint including the
+// extra params from the config. oauth2.SetAuthURLParam only supports a single
+// value per key, so the extra params are merged in to the query directly.
+// A key without a value is sent as an empty parameter.
+func authCodeURL(oauth2Config *oauth2.Config, state string, extra extra, opts ...oauth2.AuthCodeOption) (string, url.Values) {
+	opts = append([]oauth2.AuthCodeOption{oauth2.AccessTypeOnline}, opts...)
+	authURL := oauth2Config.AuthCodeURL(state, opts...)
+
+	u, err := url.Parse(authURL)
 	if err != nil {
-		fmt.Println("Error opening URL")
-		fmt.Println(err)
-		return
+		// AuthCodeURL is built from the discovered endpoint which has
+		// already been parsed, so this should not happen.
+		return authURL, nil
 	}
 
-	err = <-done
-	if err != nil {
-		fmt.Println(err)
-		return
+	q := u.Query()
+	for k, v := range extra {
+		if len(v) == 0 {
+			q[k] = []string{""}
+		} else {
+			q[k] = v
+		}
 	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), q
 }
 
 func loadConfig(path string) (TestConfig, error) {
 	cfg := TestConfig{
 		ClientPort: 4447,
+		Timeout:    5 * time.Minute,
 	}
 
 	f, err := os.Open(path)
@@ -259,107 +671,119 @@ func loadConfig(path string) (TestConfig, error) {
 	return cfg, err
 }
 
-func client(cfg TestConfig) *http.Client {
+func client(verifier *tlsVerifier) *http.Client {
 	dialer := &net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
 		DualStack: true,
 	}
 
-	transport := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           dialer.DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: cfg.Insecure,
-		},
+	newTransport := func(host string) *http.Transport {
+		return &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			TLSClientConfig:       verifier.config(host),
+		}
 	}
 
 	return &http.Client{
-		Transport: transport,
+		Transport: &hostTransport{
+			newTransport: newTransport,
+			transports:   map[string]*http.Transport{},
+		},
 	}
 }
 
-func showProvider(provider *oidc.Provider) {
+func readProvider(report *ProviderReport, provider *oidc.Provider) {
 	endpoint := provider.Endpoint()
+	report.AuthURL = endpoint.AuthURL
+	report.TokenURL = endpoint.TokenURL
 
-	fmt.Println("Resolved provider endpoint")
-	fmt.Printf("  AuthURL:   %s\n", endpoint.AuthURL)
-	fmt.Printf("  TokenURL:  %s\n", endpoint.TokenURL)
-
-	claims, err := fetchClaimsAsJSON(provider)
+	var data json.RawMessage
+	err := provider.Claims(&data)
 	if err != nil {
-		fmt.Printf("Error getting claims from provider: %v", err)
-	} else {
-		fmt.Println("Claims supported by provider")
-		fmt.Println(claims)
+		report.ClaimsErr = fmt.Errorf("error getting claims from provider: %w", err)
+		return
 	}
-}
+	report.Claims = data
 
-func fetchClaimsAsJSON(provider *oidc.Provider) (string, error) {
-	var data json.RawMessage
-	err := provider.Claims(&data)
+	err = json.Unmarshal(data, &report.Metadata)
 	if err != nil {
-		return "", err
+		report.ClaimsErr = fmt.Errorf("error parsing provider metadata: %w", err)
 	}
+}
 
-	return pp(data, "  ")
+// decodeToken decodes a JWT without verifying its signature.
+func decodeToken(str string) *TokenReport {
+	return decryptToken(str, nil)
 }
 
-func showCode(ctx context.Context, oauth2Config *oauth2.Config, code string) {
-	fmt.Println("Exchanging code for token")
-	token, err := oauth2Config.Exchange(ctx, code)
+// decryptToken is decodeToken for a token that may be a JWE, which is
+// decrypted when there is a key. A payload that is itself a token is
+// decoded into Nested, https://tools.ietf.org/html/rfc7519#section-5.2
+func decryptToken(str string, key *privateKey) *TokenReport {
+	report := &TokenReport{Raw: str, Encrypted: isJWE(str)}
 
-	if err != nil {
-		fmt.Printf("Error exchanging code for token: %v\n", err)
-		return
+	s := strings.Split(str, ".")
+	if len(s) < 2 {
+		report.Err = errors.New("jws: invalid token received")
+		return report
 	}
 
-	raw := token.Extra("id_token")
-	if raw == nil {
-		fmt.Println("Result did not contain an id_token")
-		return
+	header, err := decodeSegment(s[0])
+	if err != nil {
+		report.Err = fmt.Errorf("failed to decode jwt header: %w", err)
+		return report
 	}
+	report.Header = header
 
-	str, ok := raw.(string)
-	if !ok {
-		fmt.Println("id_token was not of type string")
-		return
+	err = json.Unmarshal(header, &report.JOSE)
+	if err != nil {
+		report.Err = fmt.Errorf("failed to parse jwt header: %w", err)
+		return report
 	}
 
-	if str == "" {
-		fmt.Println("id_token was empty")
-		return
+	var payload []byte
+	if report.Encrypted {
+		if key == nil {
+			report.Err = errors.New("the token is encrypted and there is no key to decrypt it")
+			return report
+		}
+		_, payload, err = decryptJWE(str, key)
+	} else {
+		payload, err = decodeSegment(s[1])
+		if err != nil {
+			err = fmt.Errorf("failed to decode jwt payload: %w", err)
+		}
 	}
-
-	showToken(str)
-}
-
-func showToken(str string) {
-	decoded, err := decode(str)
 	if err != nil {
-		fmt.Printf("Failed to decode jwt: %v", err)
+		report.Err = err
+		return report
 	}
 
-	fmt.Println(decoded)
-}
-
-func decode(payload string) (string, error) {
-	s := strings.Split(payload, ".")
-	if len(s) < 2 {
-		return "", errors.New("jws: invalid token received")
+	if nested := strings.TrimSpace(string(payload)); nestedTokenPattern.MatchString(nested) {
+		report.Nested = decryptToken(nested, key)
+		return report
 	}
+	report.Payload = payload
 
-	decoded, err := base64.RawURLEncoding.DecodeString(s[1])
+	err = json.Unmarshal(payload, &report.Claims)
 	if err != nil {
-		return "", err
+		report.Err = fmt.Errorf("failed to parse jwt claims: %w", err)
 	}
 
-	return pp(decoded, "  ")
+	return report
+}
+
+// decodeSegment decodes a base64url segment of a token, tolerating padding
+// that some encoders leave in.
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
 }
 
 func pp(data []byte, prefix string) (string, error) {
@@ -1,11 +1,12 @@
 package cmd
 
 import (
-	"bytes"
-	"io"
-	"os"
-	"sync"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/chilversc/oidc-debug/internal/testmock"
 	"github.com/stretchr/testify/require"
@@ -20,94 +21,198 @@ func TestTest(t *testing.T) {
 		ClientID:     "testing",
 		ClientSecret: "123456",
 		ClientPort:   4447,
-		OpenURL:      testmock.OpenURL,
+		Scopes:       []string{"openid", "email"},
+		ExtraParams: extra{
+			"resource":    {"allatclaims"},
+			"multivalued": {"a", "b"},
+			"novalue":     nil,
+		},
+		PKCE:    "S256",
+		OpenURL: testmock.OpenURL,
 	}
 
-	r, err := redirectOutput()
-	defer r.close()
-	require.NoError(t, err)
+	var tokenRequest url.Values
+	mock := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth2/token" {
+			r.ParseForm()
+			tokenRequest = r.PostForm
+		}
+		mock.ServeHTTP(w, r)
+	})
+
+	report := Test(cfg)
+
+	require.NoError(t, report.Err)
+	require.NotNil(t, report.Provider)
+	require.NoError(t, report.Provider.Err)
+	require.Equal(t, ts.URL+"/oauth2/auth", report.Provider.AuthURL)
+	require.Equal(t, ts.URL+"/oauth2/token", report.Provider.TokenURL)
+
+	require.NotNil(t, report.Authorization)
+	require.NotEmpty(t, report.Authorization.URL)
+
+	params := report.Authorization.Params
+	require.Equal(t, []string{"testing"}, params["client_id"])
+	require.Equal(t, []string{"openid email"}, params["scope"])
+	require.Equal(t, []string{"allatclaims"}, params["resource"])
+	require.Equal(t, []string{"a", "b"}, params["multivalued"])
+	require.Equal(t, []string{""}, params["novalue"])
+
+	pkce := report.Authorization.PKCE
+	require.NotNil(t, pkce)
+	require.True(t, pkce.Advertised())
+	require.Equal(t, []string{"S256"}, params["code_challenge_method"])
+	require.Equal(t, []string{pkce.Challenge}, params["code_challenge"])
+
+	require.NotEmpty(t, report.Authorization.State)
+	require.Equal(t, []string{report.Authorization.State}, params["state"])
+	require.Equal(t, []string{report.Authorization.Nonce}, params["nonce"])
+	requireCheck(t, report.Authorization.Checks, "state", true)
+
+	require.NotNil(t, report.Token)
+	require.NoError(t, report.Token.Err)
+	require.Equal(t, []string{"authorization_code"}, tokenRequest["grant_type"])
+	require.Equal(t, []string{"allatclaims"}, tokenRequest["resource"])
+	require.Equal(t, []string{"a", "b"}, tokenRequest["multivalued"])
+	require.Equal(t, "Bearer", report.Token.TokenType)
+	require.NotEmpty(t, report.Token.AccessToken)
+	require.NotEmpty(t, report.Token.RefreshToken)
+	require.Equal(t, 5*time.Minute, report.Token.ExpiresIn)
+
+	// the mock does not support the email scope
+	requireCheck(t, report.Token.Checks, "scope", false)
+
+	require.NotNil(t, report.IDToken)
+	require.NoError(t, report.IDToken.Err)
+	require.Equal(t, "someone@test", report.IDToken.Claims["sub"])
+	requireCheck(t, report.IDToken.Checks, "nonce", true)
+	requireCheck(t, report.IDToken.Checks, "alg", true)
+	requireCheck(t, report.IDToken.Checks, "kid", true)
+	requireCheck(t, report.IDToken.Checks, "iss", true)
+	requireCheck(t, report.IDToken.Checks, "aud", true)
+	requireCheck(t, report.IDToken.Checks, "exp", true)
+	requireCheck(t, report.IDToken.Checks, "nbf", true)
+
+	sig := report.IDToken.Signature
+	require.NotNil(t, sig)
+	require.NoError(t, sig.Err)
+	require.True(t, sig.Verified)
+	require.Equal(t, report.IDToken.JOSE.KeyID, sig.MatchedKey)
+}
 
-	Test(cfg)
-	stdout, _ := r.stop()
+func TestTestNotBefore(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
 
-	require.Contains(t, stdout, `"sub": "someone@test"`)
+	cfg := testConfig(ts)
+	report := Test(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+	requireCheck(t, report.IDToken.Checks, "nbf", true)
+
+	// the mock issues an ID token that is not valid for another 5 minutes
+	cfg.ExtraParams = extra{"mock_nbf": {"5m"}}
+	report = Test(cfg)
+	require.NoError(t, report.IDToken.Err)
+	requireCheck(t, report.IDToken.Checks, "nbf", false)
+	require.True(t, report.Failed())
 }
 
-// This is temporary, need to change the test function to return some
-// kind of report structure that can be inspected.
-type redirect struct {
-	stdout *stream
-	stderr *stream
-	wg     *sync.WaitGroup
-}
+func TestTestStateMismatch(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
 
-type stream struct {
-	original *os.File
-	replaced *os.File
-	capture  string
-}
+	cfg := testConfig(ts)
+	cfg.OpenURL = tamperingBrowser(func(q url.Values) { q.Set("state", "forged") })
 
-func (r *redirect) close() {
-	if r == nil {
-		return
-	}
-	if r.stdout != nil {
-		os.Stdout = r.stdout.original
-		r.stdout.replaced.Close()
-	}
-	if r.stderr != nil {
-		os.Stderr = r.stderr.original
-		r.stderr.replaced.Close()
-	}
+	report := Test(cfg)
+	require.True(t, report.Failed())
+	requireCheck(t, report.Authorization.Checks, "state", false)
+	require.Error(t, report.Authorization.Err)
+	require.NotEmpty(t, report.Authorization.Response.Get("code"))
+	require.Nil(t, report.Token)
 }
 
-func (r *redirect) stop() (string, string) {
-	r.close()
-	r.wg.Wait()
-	return r.stdout.capture, r.stderr.capture
+func TestTestTimeout(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.Timeout = 100 * time.Millisecond
+	cfg.OpenURL = func(string) error { return nil }
+
+	report := Test(cfg)
+	require.True(t, report.Failed())
+	require.Contains(t, report.Err.Error(), "timed out")
 }
 
-func redirectOutput() (*redirect, error) {
-	r := &redirect{}
-	r.wg = &sync.WaitGroup{}
-	r.wg.Add(2)
+func TestTestRedirectURI(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
 
-	stdout, err := newStream(os.Stdout, r.wg)
-	r.stdout = stdout
-	if err != nil {
-		r.close()
-		return nil, err
+	for _, host := range []string{"localhost", "127.0.0.1", "[::1]"} {
+		t.Run(host, func(t *testing.T) {
+			cfg := testConfig(ts)
+			cfg.ClientPort = 0
+			cfg.RedirectHost = host
+			cfg.CallbackPath = "/oauth2/redirect"
+
+			report := Test(cfg)
+			if report.Err != nil && strings.Contains(report.Err.Error(), "starting local server") {
+				t.Skipf("loopback address not available: %v", report.Err)
+			}
+			require.False(t, report.Failed(), "%v", report.Err)
+
+			redirect, err := url.Parse(report.Authorization.Params.Get("redirect_uri"))
+			require.NoError(t, err)
+			require.Equal(t, "http", redirect.Scheme)
+			require.Equal(t, strings.Trim(host, "[]"), redirect.Hostname())
+			require.NotEqual(t, "0", redirect.Port())
+			require.Equal(t, "/oauth2/redirect", redirect.Path)
+			require.True(t, strings.HasPrefix(report.Authorization.Callback, "/oauth2/redirect?"))
+		})
 	}
+}
 
-	stderr, err := newStream(os.Stderr, r.wg)
-	r.stderr = stderr
-	if err != nil {
-		r.close()
-		return nil, err
+func TestValidateCallbackPath(t *testing.T) {
+	cfg := TestConfig{IssuerURL: "https://idp.test/", ClientID: "testing"}
+	require.NoError(t, cfg.validate())
+
+	for _, p := range []string{"/", "/oauth2/", "/login", "/frontchannel-logout", "callback", "/a//b", "/a/../login"} {
+		cfg.CallbackPath = p
+		err := cfg.validate()
+		require.Error(t, err, p)
+		require.Contains(t, err.Error(), "callbackPath ["+p+"] is invalid")
+
+		cfg.CallbackPath = ""
+		cfg.LogoutCallbackPath = p
+		err = cfg.validate()
+		require.Error(t, err, p)
+		require.Contains(t, err.Error(), "logoutCallbackPath ["+p+"] is invalid")
+		cfg.LogoutCallbackPath = ""
 	}
 
-	os.Stdout = r.stdout.replaced
-	os.Stderr = r.stderr.replaced
-
-	return r, err
+	cfg.CallbackPath = "/logout/callback"
+	require.Contains(t, cfg.validate().Error(), "it is the same as callbackPath")
 }
 
-func newStream(original *os.File, wg *sync.WaitGroup) (*stream, error) {
-	r, w, err := os.Pipe()
-	s := &stream{original, w, ""}
-
-	// Tee the reader so that every thing we read into the buffer
-	// is also echoed to the original output stream.
-	// The test runner has better output for multiple lines wrote
-	// to stdout/err compared to the assersion error message.
-	t := io.TeeReader(r, original)
-
-	go func() {
-		buf := &bytes.Buffer{}
-		io.Copy(buf, t)
-		s.capture = buf.String()
-		wg.Done()
-	}()
+func testConfig(ts *httptest.Server) TestConfig {
+	return TestConfig{
+		IssuerURL:    ts.URL + "/",
+		ClientID:     "testing",
+		ClientSecret: "123456",
+		ClientPort:   4447,
+		OpenURL:      testmock.OpenURL,
+	}
+}
 
-	return s, err
+func requireCheck(t *testing.T, checks []Check, name string, ok bool) {
+	t.Helper()
+	for _, c := range checks {
+		if c.Name == name {
+			require.Equal(t, ok, c.OK, "check [%s]: %s", c.Name, c.Detail)
+			return
+		}
+	}
+	require.Failf(t, "check not found", "no check named [%s] in %v", name, checks)
 }
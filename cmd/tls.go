@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// TLSReport describes the certificate chain a server presented and how it
+// was verified.
+type TLSReport struct {
+	Host    string
+	Version string
+
+	// Presented is the chain sent by the server, leaf first.
+	Presented []*x509.Certificate
+
+	// Chain is the verified chain from the leaf to the root that validated
+	// it, CAPath is set when that root was loaded from caPath rather than
+	// the system roots.
+	Chain  []*x509.Certificate
+	CAPath bool
+
+	// ClientCertificate is the certificate sent for mutual TLS, nil when
+	// none is configured or the server did not request one.
+	ClientCertificate *x509.Certificate
+
+	// Insecure is set when a verification failure was ignored because
+	// of the insecure option.
+	Insecure bool
+	Err      error
+}
+
+// tlsVerifier verifies server certificates against the configured roots and
+// records the chain presented by each host.
+type tlsVerifier struct {
+	insecure bool
+
+	// roots is nil to use the system roots, caCerts are the certificates
+	// loaded from caPath.
+	roots   *x509.CertPool
+	caCerts []*x509.Certificate
+
+	// clientCert is sent when the server requests a certificate,
+	// clientLeaf is its parsed leaf.
+	clientCert *tls.Certificate
+	clientLeaf *x509.Certificate
+
+	mu      sync.Mutex
+	reports []*TLSReport
+}
+
+func newTLSVerifier(cfg TestConfig) (*tlsVerifier, error) {
+	v := &tlsVerifier{insecure: cfg.Insecure}
+
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading clientCert: %w", err)
+		}
+
+		v.clientLeaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing clientCert: %w", err)
+		}
+		v.clientCert = &cert
+	}
+
+	if cfg.CAPath == "" {
+		return v, nil
+	}
+
+	certs, err := loadCAPath(cfg.CAPath)
+	if err != nil {
+		return nil, err
+	}
+	v.caCerts = certs
+
+	if cfg.CAOnly {
+		v.roots = x509.NewCertPool()
+	} else {
+		v.roots, err = x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("error loading system roots, set caOnly to use just caPath: %w", err)
+		}
+	}
+	for _, c := range certs {
+		v.roots.AddCert(c)
+	}
+
+	return v, nil
+}
+
+// config returns the TLS config for connections to host. The standard
+// verification is replaced by verify so the presented chain can be
+// reported even when it is not trusted.
+func (v *tlsVerifier) config(host string) *tls.Config {
+	c := &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return v.verify(host, cs)
+		},
+	}
+
+	if v.clientCert != nil {
+		// Always send the certificate, rather than only when it is issued
+		// by one of the server's acceptable CAs, as self-signed certificates
+		// are allowed, https://tools.ietf.org/html/rfc8705#section-2.2
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			v.clientCertSent(host)
+			return v.clientCert, nil
+		}
+	}
+
+	return c
+}
+
+// clientThumbprint returns the x5t#S256 of the client certificate,
+// https://tools.ietf.org/html/rfc8705#section-3.1
+func (v *tlsVerifier) clientThumbprint() string {
+	if v.clientLeaf == nil {
+		return ""
+	}
+	sum := sha256.Sum256(v.clientLeaf.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// clientCertSent notes the client certificate was requested by host. The
+// server's certificate has already been verified at this point in the
+// handshake so its report has been recorded.
+func (v *tlsVerifier) clientCertSent(host string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, r := range v.reports {
+		if r.Host == host {
+			r.ClientCertificate = v.clientLeaf
+		}
+	}
+}
+
+func (v *tlsVerifier) verify(host string, cs tls.ConnectionState) error {
+	r := &TLSReport{
+		Host:      host,
+		Version:   tlsVersion(cs.Version),
+		Presented: cs.PeerCertificates,
+		Insecure:  v.insecure,
+	}
+
+	if len(cs.PeerCertificates) == 0 {
+		r.Err = errors.New("server did not present a certificate")
+	} else {
+		opts := x509.VerifyOptions{
+			Roots:         v.roots,
+			DNSName:       host,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, c := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+
+		chains, err := cs.PeerCertificates[0].Verify(opts)
+		if err != nil {
+			r.Err = err
+		} else {
+			r.Chain = chains[0]
+			root := r.Chain[len(r.Chain)-1]
+			for _, c := range v.caCerts {
+				if c.Equal(root) {
+					r.CAPath = true
+				}
+			}
+		}
+	}
+
+	v.record(r)
+	if v.insecure {
+		return nil
+	}
+	return r.Err
+}
+
+// record keeps the first report for each host, later connections to the
+// same host are expected to present the same chain.
+func (v *tlsVerifier) record(r *TLSReport) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, existing := range v.reports {
+		if existing.Host == r.Host {
+			return
+		}
+	}
+	v.reports = append(v.reports, r)
+}
+
+// results returns the TLS reports in the order the hosts were first
+// connected to.
+func (v *tlsVerifier) results() []*TLSReport {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]*TLSReport(nil), v.reports...)
+}
+
+// hostTransport uses a transport per host so the TLS config knows which
+// host it is verifying, the connection state only includes the SNI name
+// which is empty when connecting to an IP address.
+type hostTransport struct {
+	newTransport func(host string) *http.Transport
+
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+
+	t.mu.Lock()
+	transport, ok := t.transports[host]
+	if !ok {
+		transport = t.newTransport(host)
+		t.transports[host] = transport
+	}
+	t.mu.Unlock()
+
+	return transport.RoundTrip(req)
+}
+
+// loadCAPath reads the PEM certificates from path, either a single file
+// or every file in a directory.
+func loadCAPath(path string) ([]*x509.Certificate, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading caPath: %w", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading caPath: %w", err)
+		}
+
+		files = nil
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+
+	var certs []*x509.Certificate
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading caPath: %w", err)
+		}
+
+		c, err := parsePEMCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("error reading caPath [%s]: %w", file, err)
+		}
+		certs = append(certs, c...)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("caPath [%s] does not contain any PEM certificates", path)
+	}
+
+	return certs, nil
+}
+
+// parsePEMCertificates returns the certificates in data, other PEM blocks
+// such as private keys are ignored.
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+}
+
+func tlsVersion(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04x", v)
+	}
+}
+
+// fingerprint is the SHA-256 fingerprint of the DER encoded certificate.
+func fingerprint(c *x509.Certificate) string {
+	return fmt.Sprintf("%X", sha256.Sum256(c.Raw))
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestTLS(t *testing.T) {
+	ts := testmock.ServeTLS()
+	defer ts.Close()
+
+	caPath := writeCertificate(t, ts)
+
+	t.Run("caPath", func(t *testing.T) {
+		cfg := testConfig(ts)
+		cfg.OpenURL = testmock.Browser(ts.Client())
+		cfg.CAPath = caPath
+		cfg.CAOnly = true
+
+		report := Test(cfg)
+		require.False(t, report.Failed(), "%v", report.Err)
+		require.Len(t, report.TLS, 1)
+
+		r := report.TLS[0]
+		require.Equal(t, "127.0.0.1", r.Host)
+		require.NoError(t, r.Err)
+		require.Len(t, r.Presented, 1)
+		require.True(t, r.CAPath)
+		require.True(t, r.Chain[len(r.Chain)-1].Equal(ts.Certificate()))
+	})
+
+	t.Run("caPath directory", func(t *testing.T) {
+		cfg := testConfig(ts)
+		cfg.OpenURL = testmock.Browser(ts.Client())
+		cfg.CAPath = filepath.Dir(caPath)
+
+		report := Test(cfg)
+		require.False(t, report.Failed(), "%v", report.Err)
+		require.True(t, report.TLS[0].CAPath)
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		cfg := testConfig(ts)
+
+		report := Test(cfg)
+		require.True(t, report.Failed())
+		require.Error(t, report.Provider.Err)
+		require.Len(t, report.TLS, 1)
+		require.Error(t, report.TLS[0].Err)
+		require.False(t, report.TLS[0].Insecure)
+		require.Len(t, report.TLS[0].Presented, 1)
+	})
+
+	t.Run("insecure", func(t *testing.T) {
+		cfg := testConfig(ts)
+		cfg.OpenURL = testmock.Browser(ts.Client())
+		cfg.Insecure = true
+
+		report := Test(cfg)
+		require.False(t, report.Failed(), "%v", report.Err)
+		require.Error(t, report.TLS[0].Err)
+		require.True(t, report.TLS[0].Insecure)
+	})
+}
+
+func TestTestMTLS(t *testing.T) {
+	ts := testmock.ServeTLS()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.OpenURL = testmock.Browser(ts.Client())
+	cfg.CAPath = writeCertificate(t, ts)
+	cfg.ClientSecret = ""
+	cfg.ClientCert, cfg.ClientKey = writeClientCertificate(t)
+
+	report := Test(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+
+	require.NotNil(t, report.TLS[0].ClientCertificate)
+	require.Equal(t, "oidcdebug test", report.TLS[0].ClientCertificate.Subject.CommonName)
+	require.Equal(t, ts.URL+"/oauth2/mtls/token", report.Token.URL)
+
+	require.NotNil(t, report.AccessToken)
+	requireCheck(t, report.AccessToken.Checks, "cnf", true)
+	requireCheck(t, report.AccessToken.Checks, "typ", true)
+	requireCheck(t, report.IDToken.Checks, "at_hash", true)
+}
+
+func TestLoadCAPath(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := loadCAPath(filepath.Join(dir, "missing.pem"))
+	require.Error(t, err)
+
+	_, err = loadCAPath(dir)
+	require.EqualError(t, err, "caPath ["+dir+"] does not contain any PEM certificates")
+
+	err = ioutil.WriteFile(filepath.Join(dir, "bad.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("bad")}), 0600)
+	require.NoError(t, err)
+	_, err = loadCAPath(dir)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "bad.pem")
+}
+
+// writeCertificate saves the server's certificate as PEM twice in the
+// same file, as a bundle would contain more than one certificate.
+func writeCertificate(t *testing.T, ts *httptest.Server) string {
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	err := ioutil.WriteFile(path, append(block, block...), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+// writeClientCertificate creates a self-signed client certificate as used
+// by self_signed_tls_client_auth and returns the paths of the cert and key.
+func writeClientCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "oidcdebug test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	require.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), 0600))
+
+	return certPath, keyPath
+}
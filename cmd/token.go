@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EndpointReport holds a form posted to an endpoint the client authenticates
+// to, such as the token endpoint, and the response.
+type EndpointReport struct {
+	URL         string
+	Status      string
+	ContentType string
+
+	// ClientAuth is the client authentication method used and
+	// ClientAssertion the decoded JWT sent for the JWT methods.
+	ClientAuth      string
+	ClientAssertion *TokenReport
+
+	// Raw is the response body and Fields the decoded JSON object,
+	// including any non-standard fields returned by the provider.
+	Raw    []byte
+	Fields map[string]interface{}
+
+	Err error
+}
+
+// TokenResponseReport holds the response from the token endpoint.
+type TokenResponseReport struct {
+	EndpointReport
+
+	TokenType    string
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	ExpiresIn    time.Duration
+	Scope        string
+
+	Checks []Check
+}
+
+// checks returns the response's checks and those of the client assertion,
+// nil when there is no response.
+func (t *TokenResponseReport) checks() []Check {
+	if t == nil {
+		return nil
+	}
+	return append(t.ClientAssertion.checks(), t.Checks...)
+}
+
+// OAuthError is an error response as described in
+// https://tools.ietf.org/html/rfc6749#section-5.2
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	URI         string `json:"error_uri,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	msg := e.Code
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	if e.URI != "" {
+		msg += " (" + e.URI + ")"
+	}
+	return msg
+}
+
+// postForm posts form to endpoint authenticating as the configured client,
+// accept is the media type of the response. A response other than 200 OK is
+// reported as an error, using the OAuth error in the body when there is one.
+func postForm(ctx context.Context, client *http.Client, cfg *TestConfig, endpoint string, form url.Values, accept string) EndpointReport {
+	report := EndpointReport{URL: endpoint, ClientAuth: cfg.clientAuth()}
+
+	err := authenticateClient(cfg, endpoint, form, &report)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		report.Err = fmt.Errorf("error creating request: %w", err)
+		return report
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", accept)
+
+	if report.ClientAuth == authSecretBasic {
+		// https://tools.ietf.org/html/rfc6749#section-2.3.1 requires the
+		// credentials to be form encoded before being base64 encoded.
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		report.Err = fmt.Errorf("error posting to %s: %w", endpoint, err)
+		return report
+	}
+	defer res.Body.Close()
+
+	report.Status = res.Status
+	report.ContentType, _, _ = mime.ParseMediaType(res.Header.Get("Content-Type"))
+	report.Raw, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		report.Err = fmt.Errorf("error reading response: %w", err)
+		return report
+	}
+
+	if res.StatusCode != http.StatusOK {
+		oauthErr := &OAuthError{}
+		if json.Unmarshal(report.Raw, oauthErr) == nil && oauthErr.Code != "" {
+			report.Err = fmt.Errorf("endpoint returned %s: %w", res.Status, oauthErr)
+		} else {
+			report.Err = fmt.Errorf("endpoint returned %s: %s", res.Status, report.Raw)
+		}
+	}
+
+	return report
+}
+
+// decodeFields parses the response body as a JSON object.
+func (r *EndpointReport) decodeFields() error {
+	err := json.Unmarshal(r.Raw, &r.Fields)
+	if err != nil {
+		return fmt.Errorf("error parsing response: %w", err)
+	}
+	return nil
+}
+
+// requestToken posts form to the token endpoint. Unlike oauth2.Config this
+// keeps the whole response so every field the provider returned can be shown.
+func requestToken(ctx context.Context, client *http.Client, cfg *TestConfig, tokenURL string, form url.Values) *TokenResponseReport {
+	report := &TokenResponseReport{EndpointReport: postForm(ctx, client, cfg, tokenURL, form, "application/json")}
+	if report.Err != nil {
+		return report
+	}
+
+	report.Err = report.decodeFields()
+	if report.Err != nil {
+		return report
+	}
+
+	var err error
+	report.TokenType, _ = report.Fields["token_type"].(string)
+	report.AccessToken, _ = report.Fields["access_token"].(string)
+	report.RefreshToken, _ = report.Fields["refresh_token"].(string)
+	report.IDToken, _ = report.Fields["id_token"].(string)
+	report.Scope, _ = report.Fields["scope"].(string)
+	report.ExpiresIn, err = expiresIn(report.Fields["expires_in"])
+	if err != nil {
+		report.Err = err
+	}
+
+	return report
+}
+
+// expiresIn handles expires_in as either a number or, as some providers
+// send it, a string.
+func expiresIn(raw interface{}) (time.Duration, error) {
+	switch v := raw.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return time.Duration(v) * time.Second, nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expires_in [%s] is not a number", v)
+		}
+		return time.Duration(n) * time.Second, nil
+	default:
+		return 0, fmt.Errorf("expires_in is not a number: %v", raw)
+	}
+}
+
+// checkScope compares the scope granted by the token endpoint with the
+// requested scopes. An omitted scope means the requested scopes were granted,
+// https://tools.ietf.org/html/rfc6749#section-5.1
+func checkScope(requested []string, fields map[string]interface{}) Check {
+	const name = "scope"
+	raw, ok := fields["scope"]
+	if !ok {
+		return pass(name, "not returned, same as requested")
+	}
+
+	scope, ok := raw.(string)
+	if !ok {
+		return fail(name, "scope is not a space delimited string: %v", raw)
+	}
+
+	granted := strings.Fields(scope)
+	var missing, extra []string
+	for _, s := range requested {
+		if !contains(granted, s) {
+			missing = append(missing, s)
+		}
+	}
+	for _, s := range granted {
+		if !contains(requested, s) {
+			extra = append(extra, s)
+		}
+	}
+
+	switch {
+	case missing == nil && extra == nil:
+		return pass(name, "granted [%s] as requested", scope)
+	case missing == nil:
+		return fail(name, "granted [%s] includes scopes that were not requested %v", scope, extra)
+	default:
+		return fail(name, "granted [%s] is missing requested scopes %v", scope, missing)
+	}
+}
+
+// isJWT reports if the token looks like a compact serialized JWS, access
+// tokens are often opaque strings.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// secretFields are redacted from the token response and the authorization
+// response unless showSecrets is set.
+var secretFields = []string{"access_token", "refresh_token", "id_token", "device_code", "code"}
+
+// redactedFields returns a copy of fields with the secrets replaced by
+// a short prefix, enough to tell tokens apart.
+func redactedFields(fields map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		redacted[k] = v
+	}
+
+	for _, k := range secretFields {
+		if s, ok := redacted[k].(string); ok {
+			redacted[k] = redact(s)
+		}
+	}
+
+	return redacted
+}
+
+// redactedParams returns a copy of params with the secrets redacted, as
+// redactedFields does for a token response.
+func redactedParams(params url.Values) url.Values {
+	redacted := make(url.Values, len(params))
+	for k, v := range params {
+		if contains(secretFields, k) {
+			r := make([]string, len(v))
+			for i, s := range v {
+				r[i] = redact(s)
+			}
+			v = r
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// redactedURL returns the URL with the secrets in its query or fragment
+// redacted. The other parameters are left as they were received.
+func redactedURL(raw string) string {
+	i := strings.IndexAny(raw, "?#")
+	if i < 0 {
+		return raw
+	}
+
+	pairs := strings.Split(raw[i+1:], "&")
+	for i, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		k, err := url.QueryUnescape(kv[0])
+		if err != nil || len(kv) < 2 || !contains(secretFields, k) {
+			continue
+		}
+		v, err := url.QueryUnescape(kv[1])
+		if err != nil {
+			v = kv[1]
+		}
+		pairs[i] = kv[0] + "=" + redact(v)
+	}
+	return raw[:i+1] + strings.Join(pairs, "&")
+}
+
+func redact(s string) string {
+	if len(s) <= 8 {
+		return "[redacted]"
+	}
+	return fmt.Sprintf("%s...[redacted %d chars]", s[:4], len(s))
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckScope(t *testing.T) {
+	requested := []string{"openid", "email"}
+
+	require.True(t, checkScope(requested, map[string]interface{}{}).OK)
+	require.True(t, checkScope(requested, map[string]interface{}{"scope": "email openid"}).OK)
+	require.False(t, checkScope(requested, map[string]interface{}{"scope": "openid"}).OK)
+	require.False(t, checkScope(requested, map[string]interface{}{"scope": "openid email profile"}).OK)
+	require.False(t, checkScope(requested, map[string]interface{}{"scope": []interface{}{"openid", "email"}}).OK)
+}
+
+func TestExpiresIn(t *testing.T) {
+	d, err := expiresIn(float64(300))
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Minute, d)
+
+	d, err = expiresIn("3599")
+	require.NoError(t, err)
+	require.Equal(t, 3599*time.Second, d)
+
+	_, err = expiresIn("soon")
+	require.Error(t, err)
+}
+
+func TestRedactedFields(t *testing.T) {
+	fields := map[string]interface{}{
+		"access_token": "let-me-in-please",
+		"token_type":   "Bearer",
+	}
+
+	redacted := redactedFields(fields)
+	require.Equal(t, "let-...[redacted 16 chars]", redacted["access_token"])
+	require.Equal(t, "Bearer", redacted["token_type"])
+	require.Equal(t, "let-me-in-please", fields["access_token"])
+}
+
+func TestRedactedParams(t *testing.T) {
+	params := url.Values{"code": {"let-me-in-please"}, "state": {"abc"}}
+
+	redacted := redactedParams(params)
+	require.Equal(t, []string{"let-...[redacted 16 chars]"}, redacted["code"])
+	require.Equal(t, []string{"abc"}, redacted["state"])
+	require.Equal(t, "let-me-in-please", params.Get("code"))
+
+	require.Equal(t, "/callback?state=abc&code=let-...[redacted 16 chars]", redactedURL("/callback?state=abc&code=let-me-in-please"))
+	require.Equal(t, "/callback#id_token=eyJh...[redacted 11 chars]", redactedURL("/callback#id_token=eyJhbGciOi4"))
+	require.Equal(t, "/callback", redactedURL("/callback"))
+}
@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// UserInfoReport holds the response from the UserInfo endpoint and how its
+// claims compare with the ID token,
+// https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+type UserInfoReport struct {
+	URL         string
+	Status      string
+	ContentType string
+	Raw         []byte
+
+	// Encryption is the JOSE header of an encrypted response, the
+	// decrypted content is either a signed JWT or JSON.
+	Encryption json.RawMessage
+
+	// Token is the decoded response when it is a signed JWT.
+	Token *TokenReport
+
+	Claims map[string]interface{}
+
+	// Comparison lists the claims in either the ID token or the UserInfo
+	// response, leaving out the claims used to validate the ID token.
+	Comparison []ClaimComparison
+
+	Checks []Check
+	Err    error
+}
+
+// ClaimComparison is a claim from the ID token and the UserInfo response,
+// the In fields are false when the claim is missing from that source.
+type ClaimComparison struct {
+	Name       string
+	IDToken    interface{}
+	UserInfo   interface{}
+	InIDToken  bool
+	InUserInfo bool
+}
+
+// Same reports if the claim has the same value in both sources.
+func (c ClaimComparison) Same() bool {
+	return c.InIDToken && c.InUserInfo && reflect.DeepEqual(c.IDToken, c.UserInfo)
+}
+
+// validationClaims are ID token claims that validate the token or describe
+// the authentication rather than the user, they are not expected in the
+// UserInfo response.
+var validationClaims = []string{
+	"iss", "aud", "exp", "iat", "nbf", "jti", "nonce", "azp",
+	"auth_time", "acr", "amr", "at_hash", "c_hash", "s_hash", "sid",
+}
+
+// userInfo calls the UserInfo endpoint with the access token and compares
+// the claims with the ID token.
+func (f *flow) userInfo(accessToken string) {
+	m := f.report.Provider.Metadata
+	u := &UserInfoReport{URL: m.endpoint("userinfo_endpoint", m.UserInfoEndpoint, f.certThumbprint != "")}
+	f.report.UserInfo = u
+
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodGet, u.URL, nil)
+	if err != nil {
+		u.Err = fmt.Errorf("error creating UserInfo request: %w", err)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json, application/jwt")
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		u.Err = fmt.Errorf("error requesting UserInfo: %w", err)
+		return
+	}
+	defer res.Body.Close()
+
+	u.Status = res.Status
+	u.Raw, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		u.Err = fmt.Errorf("error reading UserInfo response: %w", err)
+		return
+	}
+
+	if res.StatusCode != http.StatusOK {
+		// https://tools.ietf.org/html/rfc6750#section-3
+		if challenge := res.Header.Get("WWW-Authenticate"); challenge != "" {
+			u.Err = fmt.Errorf("UserInfo endpoint returned %s: %s", res.Status, challenge)
+		} else {
+			u.Err = fmt.Errorf("UserInfo endpoint returned %s: %s", res.Status, u.Raw)
+		}
+		return
+	}
+
+	u.ContentType, _, _ = mime.ParseMediaType(res.Header.Get("Content-Type"))
+	body := string(bytes.TrimSpace(u.Raw))
+	if u.ContentType == "application/jwt" || isJWT(body) || isJWE(body) {
+		u.Claims, u.Err = f.decodeUserInfoJWT(u, body)
+	} else {
+		err = json.Unmarshal(u.Raw, &u.Claims)
+		if err != nil {
+			u.Err = fmt.Errorf("error parsing UserInfo response: %w", err)
+		}
+	}
+	if u.Claims == nil {
+		return
+	}
+
+	var idClaims map[string]interface{}
+	if t := f.report.IDToken; t != nil {
+		idClaims = t.Claims
+	}
+
+	u.Checks = append(u.Checks, checkUserInfoSubject(idClaims, u.Claims))
+	if idClaims != nil {
+		u.Comparison = compareClaims(idClaims, u.Claims)
+	}
+}
+
+// decodeUserInfoJWT decrypts and verifies a JWT UserInfo response. An
+// encrypted response may contain JSON rather than a signed JWT.
+func (f *flow) decodeUserInfoJWT(u *UserInfoReport, raw string) (map[string]interface{}, error) {
+	if isJWE(raw) {
+		if f.cfg.DecryptionKey == "" {
+			return nil, errors.New("the UserInfo response is encrypted, set decryptionKey to decrypt it")
+		}
+
+		key, err := loadPrivateKey("decryptionKey", f.cfg.DecryptionKey)
+		if err != nil {
+			return nil, err
+		}
+
+		var plaintext []byte
+		u.Encryption, plaintext, err = decryptJWE(raw, key)
+		if err != nil {
+			return nil, err
+		}
+
+		raw = string(bytes.TrimSpace(plaintext))
+		if !isJWT(raw) {
+			var claims map[string]interface{}
+			err = json.Unmarshal(plaintext, &claims)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing decrypted UserInfo response: %w", err)
+			}
+			return claims, nil
+		}
+	}
+
+	t := f.decodeVerified(raw, f.report.Provider.Metadata.UserInfoSigningAlgs)
+	u.Token = t
+	if t.Claims == nil {
+		return nil, errors.New("could not decode the signed UserInfo response")
+	}
+
+	// A signed response should contain iss and aud,
+	// https://openid.net/specs/openid-connect-core-1_0.html#UserInfoResponse
+	e := f.expectations()
+	if raw, ok := t.Claims["iss"]; ok {
+		t.Checks = append(t.Checks, checkIssuer(raw, e.Issuer))
+	}
+	if raw, ok := t.Claims["aud"]; ok {
+		t.Checks = append(t.Checks, checkAudience(audience(raw), e.ClientID))
+	}
+
+	return t.Claims, nil
+}
+
+// isJWE reports if the token looks like a compact serialized JWE, which has
+// five parts rather than the three of a JWS.
+func isJWE(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// decryptJWE returns the protected header and plaintext of a compact JWE.
+func decryptJWE(raw string, key *privateKey) (json.RawMessage, []byte, error) {
+	header, err := decodeSegment(raw[:strings.Index(raw, ".")])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode jwe header: %w", err)
+	}
+
+	jwe, err := jose.ParseEncrypted(raw)
+	if err != nil {
+		return header, nil, fmt.Errorf("could not parse JWE: %w", err)
+	}
+
+	plaintext, err := jwe.Decrypt(key.jwk.Key)
+	if err != nil {
+		return header, nil, fmt.Errorf("could not decrypt JWE: %w", err)
+	}
+
+	return header, plaintext, nil
+}
+
+// checkUserInfoSubject confirms the UserInfo response is for the user in
+// the ID token, a different sub must not be used as the response may be
+// for another user, https://openid.net/specs/openid-connect-core-1_0.html#UserInfoResponse
+func checkUserInfoSubject(idClaims, userInfo map[string]interface{}) Check {
+	const name = "sub"
+	sub, ok := userInfo["sub"]
+	switch {
+	case !ok:
+		return fail(name, "UserInfo response does not contain a sub claim")
+	case idClaims == nil:
+		return pass(name, "[%v] there is no ID token to compare with", sub)
+	case reflect.DeepEqual(idClaims["sub"], sub):
+		return pass(name, "[%v] matches the ID token", sub)
+	default:
+		return fail(name, "[%v] does not match the ID token [%v], the response must not be used", sub, idClaims["sub"])
+	}
+}
+
+// compareClaims pairs up the claims from the ID token and UserInfo
+// response, sorted by name.
+func compareClaims(idClaims, userInfo map[string]interface{}) []ClaimComparison {
+	byName := map[string]*ClaimComparison{}
+	get := func(name string) *ClaimComparison {
+		c, ok := byName[name]
+		if !ok {
+			c = &ClaimComparison{Name: name}
+			byName[name] = c
+		}
+		return c
+	}
+
+	for name, v := range idClaims {
+		if !contains(validationClaims, name) {
+			c := get(name)
+			c.IDToken, c.InIDToken = v, true
+		}
+	}
+	for name, v := range userInfo {
+		if !contains(validationClaims, name) {
+			c := get(name)
+			c.UserInfo, c.InUserInfo = v, true
+		}
+	}
+
+	comparison := make([]ClaimComparison, 0, len(byName))
+	for _, c := range byName {
+		comparison = append(comparison, *c)
+	}
+	sort.Slice(comparison, func(i, j int) bool {
+		return comparison[i].Name < comparison[j].Name
+	})
+	return comparison
+}
+
+func printUserInfo(w io.Writer, u *UserInfoReport) {
+	fmt.Fprintf(w, "  URL:       %s\n", u.URL)
+	if u.Status != "" {
+		fmt.Fprintf(w, "  Status:    %s\n", u.Status)
+	}
+	if u.ContentType != "" {
+		fmt.Fprintf(w, "  Type:      %s\n", u.ContentType)
+	}
+
+	if u.Encryption != nil {
+		fmt.Fprintln(w, "  Encryption header:")
+		printJSON(w, u.Encryption, "    ")
+	}
+
+	switch {
+	case u.Token != nil:
+		printToken(w, u.Token)
+	case u.Claims != nil:
+		data, err := json.Marshal(u.Claims)
+		if err != nil {
+			printErr(w, err)
+		} else {
+			printJSON(w, data, "  ")
+		}
+	case len(u.Raw) > 0:
+		fmt.Fprintf(w, "  %s\n", u.Raw)
+	}
+
+	if u.Comparison != nil {
+		fmt.Fprintln(w, "  Claims compared with the ID token:")
+		fmt.Fprintf(w, "    %-15s %-14s %-30s %s\n", "claim", "", "ID token", "UserInfo")
+		for _, c := range u.Comparison {
+			result := "differs"
+			switch {
+			case !c.InUserInfo:
+				result = "ID token only"
+			case !c.InIDToken:
+				result = "UserInfo only"
+			case c.Same():
+				result = "same"
+			}
+			fmt.Fprintf(w, "    %-15s %-14s %-30s %s\n", c.Name, result, claimValue(c.IDToken, c.InIDToken), claimValue(c.UserInfo, c.InUserInfo))
+		}
+	}
+
+	printChecks(w, u.Checks)
+	printErr(w, u.Err)
+}
+
+// claimValue formats a claim as compact JSON, or - when it is missing.
+func claimValue(v interface{}, present bool) string {
+	if !present {
+		return "-"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
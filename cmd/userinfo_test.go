@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/chilversc/oidc-debug/internal/testmock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestTestUserInfo(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	report := Test(testConfig(ts))
+	require.False(t, report.Failed(), "%v", report.Err)
+
+	u := report.UserInfo
+	require.NotNil(t, u)
+	require.Equal(t, ts.URL+"/userinfo", u.URL)
+	require.Equal(t, "application/json", u.ContentType)
+	require.Nil(t, u.Token)
+	requireCheck(t, u.Checks, "sub", true)
+
+	require.Contains(t, u.Comparison, ClaimComparison{Name: "email", UserInfo: "someone@test", InUserInfo: true})
+	for _, c := range u.Comparison {
+		require.NotEqual(t, "nonce", c.Name)
+		if c.Name == "sub" {
+			require.True(t, c.Same())
+		}
+	}
+}
+
+func TestTestUserInfoJWT(t *testing.T) {
+	ts := testmock.ServeTLS()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.OpenURL = testmock.Browser(ts.Client())
+	cfg.CAPath = writeCertificate(t, ts)
+	cfg.ClientSecret = ""
+	cfg.ClientCert, cfg.ClientKey = writeClientCertificate(t)
+
+	report := Test(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+
+	u := report.UserInfo
+	require.NotNil(t, u)
+	require.Equal(t, ts.URL+"/userinfo", u.URL)
+	require.Equal(t, "application/jwt", u.ContentType)
+	require.NotNil(t, u.Token)
+	requireCheck(t, u.Token.Checks, "iss", true)
+	requireCheck(t, u.Token.Checks, "aud", true)
+	requireCheck(t, u.Checks, "sub", true)
+}
+
+func TestTestSkipUserInfo(t *testing.T) {
+	ts := testmock.Serve()
+	defer ts.Close()
+
+	cfg := testConfig(ts)
+	cfg.SkipUserInfo = true
+
+	report := Test(cfg)
+	require.False(t, report.Failed(), "%v", report.Err)
+	require.Nil(t, report.UserInfo)
+}
+
+func TestDecryptJWE(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	enc, err := jose.NewEncrypter(jose.A128GCM, jose.Recipient{Algorithm: jose.RSA_OAEP, Key: &key.PublicKey}, new(jose.EncrypterOptions).WithContentType("JWT"))
+	require.NoError(t, err)
+	jwe, err := enc.Encrypt([]byte(`{"sub":"someone@test"}`))
+	require.NoError(t, err)
+	raw, err := jwe.CompactSerialize()
+	require.NoError(t, err)
+	require.True(t, isJWE(raw))
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	pk, err := loadPrivateKey("decryptionKey", path)
+	require.NoError(t, err)
+
+	header, plaintext, err := decryptJWE(raw, pk)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"sub":"someone@test"}`, string(plaintext))
+	require.Contains(t, string(header), `"RSA-OAEP"`)
+}
+
+func TestCheckUserInfoSubject(t *testing.T) {
+	id := map[string]interface{}{"sub": "a"}
+	require.True(t, checkUserInfoSubject(id, map[string]interface{}{"sub": "a"}).OK)
+	require.True(t, checkUserInfoSubject(nil, map[string]interface{}{"sub": "a"}).OK)
+	require.False(t, checkUserInfoSubject(id, map[string]interface{}{"sub": "b"}).OK)
+	require.False(t, checkUserInfoSubject(id, map[string]interface{}{}).OK)
+}
@@ -0,0 +1,401 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// checkState compares the state returned on the callback with the state
+// sent in the authorization request.
+func checkState(expected, actual string) Check {
+	const name = "state"
+	switch actual {
+	case "":
+		return fail(name, "callback did not include state, expected [%s]", expected)
+	case expected:
+		return pass(name, "callback state matches")
+	default:
+		return fail(name, "callback state [%s] does not match [%s]", actual, expected)
+	}
+}
+
+// checkNonce compares the nonce claim in the ID token with the nonce sent
+// in the authorization request.
+func checkNonce(expected string, claims map[string]interface{}) Check {
+	const name = "nonce"
+	raw, ok := claims["nonce"]
+	if !ok {
+		return fail(name, "id token does not contain a nonce claim, expected [%s]", expected)
+	}
+
+	actual, ok := raw.(string)
+	switch {
+	case !ok:
+		return fail(name, "nonce claim is not a string: %v", raw)
+	case actual == expected:
+		return pass(name, "id token nonce matches")
+	default:
+		return fail(name, "id token nonce [%s] does not match [%s]", actual, expected)
+	}
+}
+
+// claimExpectations holds the values the standard ID token claims are
+// validated against.
+type claimExpectations struct {
+	Issuer    string
+	ClientID  string
+	ClockSkew time.Duration
+	Now       time.Time
+}
+
+// checkClaims validates the standard ID token claims as described in
+// https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
+func checkClaims(claims map[string]interface{}, e claimExpectations) []Check {
+	aud := audience(claims["aud"])
+
+	return []Check{
+		checkIssuer(claims["iss"], e.Issuer),
+		checkAudience(aud, e.ClientID),
+		checkAuthorizedParty(claims["azp"], aud, e.ClientID),
+		checkExpiry(claims["exp"], e.Now, e.ClockSkew),
+		checkNotBefore(claims["nbf"], e.Now, e.ClockSkew),
+		checkIssuedAt(claims["iat"], e.Now, e.ClockSkew),
+	}
+}
+
+func checkIssuer(raw interface{}, expected string) Check {
+	const name = "iss"
+	iss, ok := raw.(string)
+	switch {
+	case raw == nil:
+		return fail(name, "id token does not contain an iss claim")
+	case !ok:
+		return fail(name, "iss claim is not a string: %v", raw)
+	case iss == expected:
+		return pass(name, "matches issuerURL [%s]", expected)
+	case strings.TrimSuffix(iss, "/") == strings.TrimSuffix(expected, "/"):
+		return fail(name, "[%s] differs from issuerURL [%s] by a trailing slash", iss, expected)
+	default:
+		return fail(name, "[%s] does not match issuerURL [%s]", iss, expected)
+	}
+}
+
+func checkAudience(aud []string, clientID string) Check {
+	const name = "aud"
+	switch {
+	case len(aud) == 0:
+		return fail(name, "id token does not contain an aud claim")
+	case contains(aud, clientID):
+		return pass(name, "contains clientID [%s]", clientID)
+	default:
+		return fail(name, "%v does not contain clientID [%s]", aud, clientID)
+	}
+}
+
+func checkAuthorizedParty(raw interface{}, aud []string, clientID string) Check {
+	const name = "azp"
+	azp, ok := raw.(string)
+	switch {
+	case raw == nil && len(aud) > 1:
+		return fail(name, "required when there are multiple audiences %v", aud)
+	case raw == nil:
+		return pass(name, "not present, single audience")
+	case !ok:
+		return fail(name, "azp claim is not a string: %v", raw)
+	case azp == clientID:
+		return pass(name, "matches clientID [%s]", clientID)
+	default:
+		return fail(name, "[%s] does not match clientID [%s]", azp, clientID)
+	}
+}
+
+func checkExpiry(raw interface{}, now time.Time, skew time.Duration) Check {
+	const name = "exp"
+	if raw == nil {
+		return fail(name, "id token does not contain an exp claim")
+	}
+
+	exp, ok := numericDate(raw)
+	switch {
+	case !ok:
+		return fail(name, "exp claim is not a NumericDate: %v", raw)
+	case now.Add(-skew).Before(exp):
+		return pass(name, "expires in %s at %s", humanDuration(exp.Sub(now)), formatTime(exp))
+	default:
+		return fail(name, "expired %s ago at %s", humanDuration(now.Sub(exp)), formatTime(exp))
+	}
+}
+
+func checkNotBefore(raw interface{}, now time.Time, skew time.Duration) Check {
+	const name = "nbf"
+	if raw == nil {
+		return pass(name, "not present")
+	}
+
+	nbf, ok := numericDate(raw)
+	switch {
+	case !ok:
+		return fail(name, "nbf claim is not a NumericDate: %v", raw)
+	case !now.Add(skew).Before(nbf):
+		return pass(name, "valid since %s ago at %s", humanDuration(now.Sub(nbf)), formatTime(nbf))
+	default:
+		return fail(name, "not valid for another %s until %s", humanDuration(nbf.Sub(now)), formatTime(nbf))
+	}
+}
+
+func checkIssuedAt(raw interface{}, now time.Time, skew time.Duration) Check {
+	const name = "iat"
+	if raw == nil {
+		return fail(name, "id token does not contain an iat claim")
+	}
+
+	iat, ok := numericDate(raw)
+	switch {
+	case !ok:
+		return fail(name, "iat claim is not a NumericDate: %v", raw)
+	case !now.Add(skew).Before(iat):
+		return pass(name, "issued %s ago at %s", humanDuration(now.Sub(iat)), formatTime(iat))
+	default:
+		return fail(name, "issued %s in the future at %s", humanDuration(iat.Sub(now)), formatTime(iat))
+	}
+}
+
+// audience handles the aud claim being either a single string or an array.
+func audience(raw interface{}) []string {
+	switch v := raw.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		aud := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+		return aud
+	default:
+		return nil
+	}
+}
+
+// numericDate converts a JSON number of seconds since the epoch to a time.
+func numericDate(raw interface{}) (time.Time, bool) {
+	f, ok := raw.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), true
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format(time.RFC3339)
+}
+
+// humanDuration rounds d to the second so it reads as 4m59s
+// instead of 4m59.998123s.
+func humanDuration(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
+
+// checkAccessTokenClaims validates a JWT access token against
+// https://tools.ietf.org/html/rfc9068#section-4
+func checkAccessTokenClaims(h JOSEHeader, claims map[string]interface{}, e claimExpectations) []Check {
+	checks := []Check{
+		checkAccessTokenType(h.Type),
+		checkIssuer(claims["iss"], e.Issuer),
+		checkExpiry(claims["exp"], e.Now, e.ClockSkew),
+		checkIssuedAt(claims["iat"], e.Now, e.ClockSkew),
+		checkClientID(claims["client_id"], e.ClientID),
+	}
+
+	for _, name := range []string{"aud", "sub", "jti"} {
+		if _, ok := claims[name]; ok {
+			checks = append(checks, pass(name, "present"))
+		} else {
+			checks = append(checks, fail(name, "access token does not contain a %s claim", name))
+		}
+	}
+
+	return checks
+}
+
+// checkRequestedAudience confirms the access token is for the resource or audience
+// that was requested, https://tools.ietf.org/html/rfc8707#section-2
+func checkRequestedAudience(aud, requested []string) Check {
+	const name = "audience"
+	var missing []string
+	for _, r := range requested {
+		if !contains(aud, r) {
+			missing = append(missing, r)
+		}
+	}
+
+	switch {
+	case len(requested) == 0:
+		return pass(name, "%v, no resource or audience was requested", aud)
+	case missing != nil:
+		return fail(name, "%v does not include the requested %v", aud, missing)
+	default:
+		return pass(name, "%v includes the requested %v", aud, requested)
+	}
+}
+
+func checkAccessTokenType(typ string) Check {
+	const name = "typ"
+	switch strings.ToLower(typ) {
+	case "at+jwt", "application/at+jwt":
+		return pass(name, "[%s]", typ)
+	case "":
+		return fail(name, "header does not contain a typ, expected [at+jwt]")
+	default:
+		return fail(name, "[%s] expected [at+jwt]", typ)
+	}
+}
+
+func checkClientID(raw interface{}, clientID string) Check {
+	const name = "client_id"
+	id, ok := raw.(string)
+	switch {
+	case raw == nil:
+		return fail(name, "access token does not contain a client_id claim")
+	case !ok:
+		return fail(name, "client_id claim is not a string: %v", raw)
+	case id == clientID:
+		return pass(name, "matches clientID [%s]", clientID)
+	default:
+		return fail(name, "[%s] does not match clientID [%s]", id, clientID)
+	}
+}
+
+// checkCertificateBinding confirms the access token is bound to the client
+// certificate, https://tools.ietf.org/html/rfc8705#section-3.1
+func checkCertificateBinding(claims map[string]interface{}, thumbprint string) Check {
+	const name = "cnf"
+	cnf, ok := claims["cnf"].(map[string]interface{})
+	if !ok {
+		return fail(name, "access token does not contain a cnf claim, it is not bound to the client certificate")
+	}
+
+	x5t, ok := cnf["x5t#S256"].(string)
+	switch {
+	case !ok:
+		return fail(name, "cnf claim does not contain x5t#S256, it is not bound to the client certificate")
+	case x5t == thumbprint:
+		return pass(name, "x5t#S256 matches the client certificate")
+	default:
+		return fail(name, "x5t#S256 [%s] does not match the client certificate [%s]", x5t, thumbprint)
+	}
+}
+
+// checkHash validates the at_hash or c_hash claim against the access token
+// or code returned alongside the ID token. No check is made when value is
+// empty as the claim is only required when the value was issued with the ID
+// token, https://openid.net/specs/openid-connect-core-1_0.html#HybridIDToken
+func checkHash(name string, claims map[string]interface{}, alg, value string) []Check {
+	if value == "" {
+		return nil
+	}
+
+	raw, ok := claims[name]
+	if !ok {
+		return []Check{fail(name, "id token does not contain %s, required when issued with the %s", name, hashSubject(name))}
+	}
+
+	actual, ok := raw.(string)
+	if !ok {
+		return []Check{fail(name, "%s claim is not a string: %v", name, raw)}
+	}
+
+	expected, err := halfHash(alg, value)
+	switch {
+	case err != nil:
+		return []Check{fail(name, "%v", err)}
+	case actual == expected:
+		return []Check{pass(name, "matches the %s", hashSubject(name))}
+	default:
+		return []Check{fail(name, "[%s] does not match the %s, expected [%s]", actual, hashSubject(name), expected)}
+	}
+}
+
+func hashSubject(name string) string {
+	if name == "c_hash" {
+		return "code"
+	}
+	return "access token"
+}
+
+// halfHash is the base64url encoding of the left-most half of the hash of
+// value, using the hash from the ID token's alg.
+func halfHash(alg, value string) (string, error) {
+	var h hash.Hash
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		h = sha256.New()
+	case strings.HasSuffix(alg, "384"):
+		h = sha512.New384()
+	case strings.HasSuffix(alg, "512"), alg == "EdDSA":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("can not determine the hash for alg [%s]", alg)
+	}
+
+	h.Write([]byte(value))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
+// checkResponseType confirms the callback contains a parameter for every
+// part of the requested response_type.
+func checkResponseType(responseType string, response url.Values) Check {
+	const name = "response_type"
+	params := map[string]string{
+		"code":     "code",
+		"id_token": "id_token",
+		"token":    "access_token",
+	}
+
+	var missing []string
+	for _, t := range strings.Fields(responseType) {
+		if response.Get(params[t]) == "" {
+			missing = append(missing, params[t])
+		}
+	}
+
+	if missing != nil {
+		return fail(name, "requested [%s] but the response is missing %v", responseType, missing)
+	}
+	return pass(name, "response contains everything requested by [%s]", responseType)
+}
+
+// checkResponseMode compares the response mode the provider used with the
+// one requested, a mode the provider does not advertise is a misconfiguration
+// even when the provider honoured it.
+func checkResponseMode(requested, actual string, supported []string) Check {
+	const name = "response_mode"
+	switch {
+	case requested != actual:
+		return fail(name, "requested [%s] but the provider used [%s]", requested, actual)
+	case supported != nil && !responseModeSupported(requested, supported):
+		return fail(name, "[%s] was used but it is not in the provider's response_modes_supported %v", actual, supported)
+	default:
+		return pass(name, "[%s] as requested", actual)
+	}
+}
+
+// responseModeSupported reports if the provider advertises the mode. The
+// jwt shortcut advertises JARM with the default mode of the response type,
+// query.jwt or fragment.jwt, https://openid.net/specs/oauth-v2-jarm.html#section-2.3.4
+func responseModeSupported(mode string, supported []string) bool {
+	if contains(supported, mode) {
+		return true
+	}
+	return (mode == "query.jwt" || mode == "fragment.jwt") && contains(supported, "jwt")
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckState(t *testing.T) {
+	require.True(t, checkState("abc", "abc").OK)
+	require.False(t, checkState("abc", "").OK)
+	require.False(t, checkState("abc", "xyz").OK)
+}
+
+func TestCheckNonce(t *testing.T) {
+	require.True(t, checkNonce("abc", map[string]interface{}{"nonce": "abc"}).OK)
+	require.False(t, checkNonce("abc", map[string]interface{}{}).OK)
+	require.False(t, checkNonce("abc", map[string]interface{}{"nonce": "xyz"}).OK)
+	require.False(t, checkNonce("abc", map[string]interface{}{"nonce": 123}).OK)
+}
+
+func TestCheckClaims(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	e := claimExpectations{
+		Issuer:    "https://issuer.test/",
+		ClientID:  "client",
+		ClockSkew: time.Minute,
+		Now:       now,
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": "https://issuer.test/",
+			"aud": "client",
+			"exp": float64(now.Add(5 * time.Minute).Unix()),
+			"nbf": float64(now.Unix()),
+			"iat": float64(now.Unix()),
+		}
+	}
+
+	checks := checkClaims(valid(), e)
+	for _, c := range checks {
+		require.True(t, c.OK, "check [%s]: %s", c.Name, c.Detail)
+	}
+
+	tests := []struct {
+		name  string
+		check string
+		set   map[string]interface{}
+	}{
+		{"trailing slash", "iss", map[string]interface{}{"iss": "https://issuer.test"}},
+		{"wrong audience", "aud", map[string]interface{}{"aud": "other"}},
+		{"multiple audiences without azp", "azp", map[string]interface{}{"aud": []interface{}{"client", "other"}}},
+		{"azp for another client", "azp", map[string]interface{}{"azp": "other"}},
+		{"expired", "exp", map[string]interface{}{"exp": float64(now.Add(-2 * time.Minute).Unix())}},
+		{"not yet valid", "nbf", map[string]interface{}{"nbf": float64(now.Add(2 * time.Minute).Unix())}},
+		{"issued in the future", "iat", map[string]interface{}{"iat": float64(now.Add(2 * time.Minute).Unix())}},
+		{"missing exp", "exp", map[string]interface{}{"exp": nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			for k, v := range tt.set {
+				if v == nil {
+					delete(claims, k)
+				} else {
+					claims[k] = v
+				}
+			}
+			requireCheck(t, checkClaims(claims, e), tt.check, false)
+		})
+	}
+
+	t.Run("within clock skew", func(t *testing.T) {
+		claims := valid()
+		claims["exp"] = float64(now.Add(-30 * time.Second).Unix())
+		claims["nbf"] = float64(now.Add(30 * time.Second).Unix())
+		requireCheck(t, checkClaims(claims, e), "exp", true)
+		requireCheck(t, checkClaims(claims, e), "nbf", true)
+	})
+}
+
+func TestCheckHash(t *testing.T) {
+	sum := sha256.Sum256([]byte("let-me-in"))
+	atHash := base64.RawURLEncoding.EncodeToString(sum[:16])
+
+	checks := checkHash("at_hash", map[string]interface{}{"at_hash": atHash}, "RS256", "let-me-in")
+	requireCheck(t, checks, "at_hash", true)
+
+	checks = checkHash("at_hash", map[string]interface{}{"at_hash": atHash}, "RS256", "another-token")
+	requireCheck(t, checks, "at_hash", false)
+
+	checks = checkHash("c_hash", map[string]interface{}{}, "RS256", "some-code")
+	requireCheck(t, checks, "c_hash", false)
+
+	require.Empty(t, checkHash("c_hash", map[string]interface{}{}, "RS256", ""))
+}
+
+func TestCheckResponseType(t *testing.T) {
+	response := url.Values{"code": {"abc"}, "id_token": {"x.y.z"}}
+	require.True(t, checkResponseType("code id_token", response).OK)
+	require.False(t, checkResponseType("code id_token token", response).OK)
+}
+
+func TestCheckResponseMode(t *testing.T) {
+	supported := []string{"query", "fragment"}
+	require.True(t, checkResponseMode("query", "query", supported).OK)
+	require.False(t, checkResponseMode("form_post", "form_post", supported).OK)
+	require.False(t, checkResponseMode("form_post", "query", supported).OK)
+	require.False(t, checkResponseMode("query.jwt", "query", supported).OK)
+	require.False(t, checkResponseMode("fragment.jwt", "fragment.jwt", supported).OK)
+	require.True(t, checkResponseMode("fragment.jwt", "fragment.jwt", nil).OK)
+
+	// a provider may advertise JARM with just the jwt shortcut
+	jwt := []string{"query", "jwt"}
+	require.True(t, checkResponseMode("query.jwt", "query.jwt", jwt).OK)
+	require.True(t, checkResponseMode("fragment.jwt", "fragment.jwt", jwt).OK)
+	require.False(t, checkResponseMode("form_post.jwt", "form_post.jwt", jwt).OK)
+}
+
+func TestCheckCertificateBinding(t *testing.T) {
+	bound := map[string]interface{}{"cnf": map[string]interface{}{"x5t#S256": "abc"}}
+	require.True(t, checkCertificateBinding(bound, "abc").OK)
+	require.False(t, checkCertificateBinding(bound, "xyz").OK)
+	require.False(t, checkCertificateBinding(map[string]interface{}{}, "abc").OK)
+	require.False(t, checkCertificateBinding(map[string]interface{}{"cnf": map[string]interface{}{"jkt": "abc"}}, "abc").OK)
+}
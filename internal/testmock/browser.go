@@ -0,0 +1,109 @@
+package testmock
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// OpenURL mimics the standard client browser by following the redirects.
+// This is designed to work with testmock.Serve and expects every request
+// to return a redirect except the last request.
+//
+// Browsers do not send the URL fragment to the server, if the last redirect
+// contained a fragment OpenURL does what the oidcdebug callback page's script
+// would do and posts the fragment to /fragment on the same host.
+//
+// When the last response is a form_post page OpenURL submits the form as
+// the page's script would.
+func OpenURL(url string) error {
+	return openURL(http.DefaultClient, url)
+}
+
+// Browser returns an OpenURL that makes its requests with client, such as
+// the client of a server started by ServeTLS.
+func Browser(client *http.Client) func(url string) error {
+	return func(url string) error {
+		return openURL(client, url)
+	}
+}
+
+func openURL(client *http.Client, url string) error {
+	// client.Get will automatically follow up to 10 redirects
+	// which is enough for the oauth dance.
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+
+	body, err := readResponse(res)
+	if err != nil {
+		return err
+	}
+
+	final := res.Request.URL
+	if final.Fragment != "" {
+		target := *final
+		target.Path = "/fragment"
+		target.RawQuery = ""
+		target.Fragment = ""
+
+		res, err = client.Post(target.String(), "application/x-www-form-urlencoded", strings.NewReader(final.EscapedFragment()))
+		if err != nil {
+			return err
+		}
+		_, err = readResponse(res)
+		return err
+	}
+
+	action, form, ok := parseFormPost(body)
+	if ok {
+		res, err = client.PostForm(action, form)
+		if err != nil {
+			return err
+		}
+		_, err = readResponse(res)
+		return err
+	}
+
+	return nil
+}
+
+func readResponse(res *http.Response) (string, error) {
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("server response %s : %s", res.Status, body)
+	}
+	return string(body), nil
+}
+
+var (
+	formAction  = regexp.MustCompile(`<form method="post" action="([^"]*)">`)
+	hiddenInput = regexp.MustCompile(`<input type="hidden" name="([^"]*)" value="([^"]*)"/>`)
+)
+
+// parseFormPost reads the form from the page written by writeFormPost,
+// this is not a general purpose HTML parser.
+func parseFormPost(body string) (string, url.Values, bool) {
+	m := formAction.FindStringSubmatch(body)
+	if m == nil {
+		return "", nil, false
+	}
+
+	form := url.Values{}
+	for _, input := range hiddenInput.FindAllStringSubmatch(body, -1) {
+		form.Add(html.UnescapeString(input[1]), html.UnescapeString(input[2]))
+	}
+
+	return html.UnescapeString(m[1]), form, true
+}
@@ -0,0 +1,175 @@
+package testmock
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	deviceCodeGrant    = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceCodeLifetime = 10 * time.Minute
+
+	// DeviceInterval is the polling interval returned by the device
+	// authorization endpoint.
+	DeviceInterval = 5 * time.Second
+)
+
+// device is a pending device authorization request,
+// https://tools.ietf.org/html/rfc8628#section-3.2
+type device struct {
+	userCode string
+	auth     url.Values
+	approved bool
+	expires  time.Time
+}
+
+// deviceStore holds the device authorization requests by device code.
+type deviceStore struct {
+	mu      sync.Mutex
+	devices map[string]*device
+}
+
+func (s *deviceStore) issue(auth url.Values) (string, *device, error) {
+	code, err := randomString()
+	if err != nil {
+		return "", nil, err
+	}
+
+	user, err := randomString()
+	if err != nil {
+		return "", nil, err
+	}
+
+	d := &device{
+		userCode: strings.ToUpper(user[:8]),
+		auth:     auth,
+		expires:  time.Now().Add(deviceCodeLifetime),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[code] = d
+
+	return code, d, nil
+}
+
+// approve marks the request with the user code as approved, as if the
+// user had signed in on another device.
+func (s *deviceStore) approve(userCode string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.devices {
+		if d.userCode == userCode {
+			d.approved = true
+			return true
+		}
+	}
+	return false
+}
+
+// redeem returns the request for the device code once it has been approved,
+// otherwise the error code for the token response.
+func (s *deviceStore) redeem(code string) (*device, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.devices[code]
+	switch {
+	case !ok:
+		return nil, "invalid_grant"
+	case time.Now().After(d.expires):
+		delete(s.devices, code)
+		return nil, "expired_token"
+	case !d.approved:
+		return d, "authorization_pending"
+	default:
+		delete(s.devices, code)
+		return d, ""
+	}
+}
+
+type deviceAuthHandler struct {
+	devices *deviceStore
+}
+
+// ServeHTTP handles the device authorization request,
+// https://tools.ietf.org/html/rfc8628#section-3.1
+func (h deviceAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	clientID := requestClientID(r)
+	if clientID == "" {
+		writeError(w, "invalid_client", "client_id is required")
+		return
+	}
+
+	err := authenticateClient(r, clientID)
+	if err != nil {
+		writeError(w, "invalid_client", err.Error())
+		return
+	}
+
+	auth := url.Values{
+		"client_id": {clientID},
+		"scope":     {r.PostFormValue("scope")},
+	}
+
+	code, d, err := h.devices.issue(auth)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not issue device code : %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	verify := scheme + "://" + r.Host + "/oauth2/device/verify"
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]interface{}{
+		"device_code":               code,
+		"user_code":                 d.userCode,
+		"verification_uri":          verify,
+		"verification_uri_complete": verify + "?" + url.Values{"user_code": {d.userCode}}.Encode(),
+		"expires_in":                uint32(deviceCodeLifetime / time.Second),
+		"interval":                  uint32(DeviceInterval / time.Second),
+	})
+}
+
+type deviceVerifyHandler struct {
+	devices *deviceStore
+}
+
+// ServeHTTP approves the request for the user_code param, there is no
+// sign in or consent in the mock.
+func (h deviceVerifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.devices.approve(r.URL.Query().Get("user_code")) {
+		http.Error(w, "unknown user_code", http.StatusNotFound)
+		return
+	}
+	w.Write([]byte("Device approved"))
+}
+
+// deviceCode polls for the tokens of a device authorization request,
+// https://tools.ietf.org/html/rfc8628#section-3.4
+func (h tokenHandler) deviceCode(w http.ResponseWriter, r *http.Request) {
+	d, code := h.devices.redeem(r.PostFormValue("device_code"))
+	if d != nil {
+		err := authenticateClient(r, d.auth.Get("client_id"))
+		if err != nil {
+			writeError(w, "invalid_client", err.Error())
+			return
+		}
+	}
+
+	if code != "" {
+		writeError(w, code, "")
+		return
+	}
+
+	h.issue(w, r, d.auth, "")
+}
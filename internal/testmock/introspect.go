@@ -0,0 +1,184 @@
+package testmock
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const introspectionJWT = "application/token-introspection+jwt"
+
+// opaqueToken is an issued opaque access token, tokens issued alongside a
+// refresh token share its family.
+type opaqueToken struct {
+	auth    url.Values
+	family  string
+	issued  time.Time
+	expires time.Time
+}
+
+// accessStore remembers the opaque access tokens so they can be used with
+// the UserInfo endpoint and introspected.
+type accessStore struct {
+	mu     sync.Mutex
+	tokens map[string]*opaqueToken
+}
+
+// issue creates an opaque access token for auth in family.
+func (s *accessStore) issue(auth url.Values, family string) (string, error) {
+	token, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = &opaqueToken{auth: auth, family: family, issued: now, expires: now.Add(accessTokenLifetime)}
+
+	return token, nil
+}
+
+// lookup returns the token, nil when the token is unknown or expired.
+func (s *accessStore) lookup(token string) *opaqueToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[token]
+	if !ok || time.Now().After(t.expires) {
+		return nil
+	}
+	return t
+}
+
+// lookup returns the refresh token without using it, nil when the token is
+// unknown, revoked or has already been used.
+func (s *refreshStore) lookup(token string) *refreshToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[token]
+	if !ok || t.used || s.revoked[t.family] {
+		return nil
+	}
+	return t
+}
+
+type introspectionHandler struct {
+	access        *accessStore
+	refreshTokens *refreshStore
+	tokens        *tokenIssuer
+}
+
+// ServeHTTP describes the token to an authenticated client,
+// https://tools.ietf.org/html/rfc7662#section-2. The response is a signed
+// JWT when the client accepts one, https://www.rfc-editor.org/rfc/rfc9701
+func (h introspectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !hasClientCredentials(r) {
+		writeError(w, "invalid_client", "introspection requires client authentication")
+		return
+	}
+
+	clientID := requestClientID(r)
+	err := authenticateClient(r, clientID)
+	if err != nil {
+		writeError(w, "invalid_client", err.Error())
+		return
+	}
+
+	response := h.describe(r.PostFormValue("token"), r.PostFormValue("token_type_hint"))
+
+	if !strings.Contains(r.Header.Get("Accept"), introspectionJWT) {
+		writeJSON(w, response)
+		return
+	}
+
+	signed, err := h.tokens.sign("token-introspection+jwt", map[string]interface{}{
+		"iss":                 h.tokens.issuer,
+		"aud":                 clientID,
+		"iat":                 jwt.NewNumericDate(time.Now()),
+		"token_introspection": response,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not sign jwt : %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", introspectionJWT)
+	w.Write([]byte(signed))
+}
+
+// describe returns the introspection response for the token, the hint
+// is only used to pick which store to search first.
+func (h introspectionHandler) describe(token, hint string) map[string]interface{} {
+	lookups := []func(string) map[string]interface{}{h.describeAccess, h.describeRefresh}
+	if hint == "refresh_token" {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		if response := lookup(token); response != nil {
+			return response
+		}
+	}
+
+	// https://tools.ietf.org/html/rfc7662#section-2.2
+	return map[string]interface{}{"active": false}
+}
+
+func (h introspectionHandler) describeAccess(token string) map[string]interface{} {
+	if t := h.access.lookup(token); t != nil {
+		return map[string]interface{}{
+			"active":     true,
+			"token_type": "Bearer",
+			"scope":      grantedScope(t.auth.Get("scope")),
+			"client_id":  t.auth.Get("client_id"),
+			"sub":        "someone@test",
+			"iat":        t.issued.Unix(),
+			"exp":        t.expires.Unix(),
+			"group":      []string{"devs@test", "users@test"},
+		}
+	}
+
+	// JWT access tokens are described from their claims, the signature is
+	// not checked as the mock only needs to handle the tokens it issued.
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil
+	}
+
+	claims := map[string]interface{}{}
+	err = parsed.UnsafeClaimsWithoutVerification(&claims)
+	if err != nil {
+		return nil
+	}
+
+	exp, _ := claims["exp"].(float64)
+	if time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil
+	}
+
+	claims["active"] = true
+	claims["token_type"] = "Bearer"
+	return claims
+}
+
+func (h introspectionHandler) describeRefresh(token string) map[string]interface{} {
+	t := h.refreshTokens.lookup(token)
+	if t == nil {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"active":     true,
+		"token_type": "refresh_token",
+		"scope":      grantedScope(t.auth.Get("scope")),
+		"client_id":  t.auth.Get("client_id"),
+		"sub":        "someone@test",
+	}
+}
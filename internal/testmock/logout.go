@@ -0,0 +1,151 @@
+package testmock
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type endSessionHandler struct {
+	tokens *tokenIssuer
+}
+
+// ServeHTTP logs the user out and redirects to the post_logout_redirect_uri,
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html#RPLogout
+// There are no sessions in the mock so there is nothing to end. The
+// id_token_hint signature is not checked, only that it was issued by the
+// mock to the client. When there is a hint a back-channel logout token is
+// sent for its session before redirecting.
+func (h endSessionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method [%s] not allowed for URL [%s]", r.Method, r.URL.String()), http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid request : %v", err), http.StatusBadRequest)
+		return
+	}
+
+	clientID := r.Form.Get("client_id")
+	var session *hintSession
+	if hint := r.Form.Get("id_token_hint"); hint != "" {
+		session, err = h.hintSession(hint, clientID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		clientID = session.clientID
+	}
+
+	redirect := r.Form.Get("post_logout_redirect_uri")
+	if redirect == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("You have been logged out"))
+		return
+	}
+
+	if clientID == "" {
+		http.Error(w, "post_logout_redirect_uri requires an id_token_hint or client_id", http.StatusBadRequest)
+		return
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil || !u.IsAbs() {
+		http.Error(w, fmt.Sprintf("post_logout_redirect_uri [%s] is not an absolute URL", redirect), http.StatusBadRequest)
+		return
+	}
+
+	if session != nil {
+		h.notifyBackchannel(u, session)
+	}
+
+	if state := r.Form.Get("state"); state != "" {
+		q := u.Query()
+		q.Set("state", state)
+		u.RawQuery = q.Encode()
+	}
+
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+// hintSession is the client and session an id_token_hint was issued for.
+type hintSession struct {
+	clientID string
+	subject  string
+	sid      string
+}
+
+// hintSession returns the session the ID token was issued for, an error
+// when the token was not issued by the mock or not to clientID.
+func (h endSessionHandler) hintSession(hint, clientID string) (*hintSession, error) {
+	token, err := jwt.ParseSigned(hint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token_hint : %w", err)
+	}
+
+	var claims jwt.Claims
+	var extra struct {
+		Sid string `json:"sid"`
+	}
+	err = token.UnsafeClaimsWithoutVerification(&claims, &extra)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token_hint : %w", err)
+	}
+
+	switch {
+	case claims.Issuer != h.tokens.issuer:
+		return nil, fmt.Errorf("id_token_hint was issued by [%s]", claims.Issuer)
+	case len(claims.Audience) == 0:
+		return nil, errors.New("id_token_hint does not contain an aud")
+	case clientID != "" && !claims.Audience.Contains(clientID):
+		return nil, fmt.Errorf("id_token_hint was not issued to client [%s]", clientID)
+	case clientID == "":
+		clientID = claims.Audience[0]
+	}
+
+	return &hintSession{clientID: clientID, subject: claims.Subject, sid: extra.Sid}, nil
+}
+
+// notifyBackchannel posts a logout token for the session,
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#BCRequest
+// There are no client registrations in the mock, so it is sent to
+// /backchannel-logout on the host of the post_logout_redirect_uri, where
+// oidcdebug listens. Failures are ignored as they do not stop the logout.
+func (h endSessionHandler) notifyBackchannel(redirect *url.URL, session *hintSession) {
+	jti, err := randomString()
+	if err != nil {
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss": h.tokens.issuer,
+		"aud": session.clientID,
+		"iat": jwt.NewNumericDate(time.Now()),
+		"jti": jti,
+		"sub": session.subject,
+		"events": map[string]interface{}{
+			"http://schemas.openid.net/event/backchannel-logout": map[string]interface{}{},
+		},
+	}
+	if session.sid != "" {
+		claims["sid"] = session.sid
+	}
+
+	token, err := h.tokens.sign("logout+jwt", claims)
+	if err != nil {
+		return
+	}
+
+	target := url.URL{Scheme: redirect.Scheme, Host: redirect.Host, Path: "/backchannel-logout"}
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.PostForm(target.String(), url.Values{"logout_token": {token}})
+	if err == nil {
+		res.Body.Close()
+	}
+}
@@ -7,6 +7,7 @@ func wellKnownMetadata(scheme, host string) string {
   "issuer": "%[1]s://%[2]s/",
   "authorization_endpoint": "%[1]s://%[2]s/oauth2/auth",
   "token_endpoint": "%[1]s://%[2]s/oauth2/token",
+  "device_authorization_endpoint": "%[1]s://%[2]s/oauth2/device/auth",
   "jwks_uri": "%[1]s://%[2]s/.well-known/jwks.json",
   "subject_types_supported": [
     "public"
@@ -27,11 +28,21 @@ func wellKnownMetadata(scheme, host string) string {
     "authorization_code",
     "implicit",
     "client_credentials",
-    "refresh_token"
+    "refresh_token",
+    "urn:ietf:params:oauth:grant-type:device_code"
   ],
   "response_modes_supported": [
     "query",
-    "fragment"
+    "fragment",
+    "form_post",
+    "jwt",
+    "query.jwt",
+    "fragment.jwt",
+    "form_post.jwt"
+  ],
+  "code_challenge_methods_supported": [
+    "plain",
+    "S256"
   ],
   "userinfo_endpoint": "%[1]s://%[2]s/userinfo",
   "scopes_supported": [
@@ -42,25 +53,47 @@ func wellKnownMetadata(scheme, host string) string {
   "token_endpoint_auth_methods_supported": [
     "client_secret_post",
     "client_secret_basic",
+    "client_secret_jwt",
     "private_key_jwt",
+    "tls_client_auth",
+    "self_signed_tls_client_auth",
     "none"
   ],
+  "token_endpoint_auth_signing_alg_values_supported": [
+    "HS256",
+    "RS256",
+    "PS256",
+    "ES256"
+  ],
   "userinfo_signing_alg_values_supported": [
     "none",
-    "RS256"
+    "RS256",
+    "PS256"
+  ],
+  "authorization_signing_alg_values_supported": [
+    "PS256"
   ],
   "id_token_signing_alg_values_supported": [
-    "RS256"
+    "RS256",
+    "PS256"
   ],
   "request_parameter_supported": true,
   "request_uri_parameter_supported": true,
   "require_request_uri_registration": true,
   "claims_parameter_supported": false,
+  "introspection_endpoint": "%[1]s://%[2]s/oauth2/introspect",
+  "introspection_signing_alg_values_supported": [
+    "PS256"
+  ],
   "revocation_endpoint": "%[1]s://%[2]s/oauth2/revoke",
   "backchannel_logout_supported": true,
   "backchannel_logout_session_supported": true,
   "frontchannel_logout_supported": true,
   "frontchannel_logout_session_supported": true,
-  "end_session_endpoint": "%[1]s://%[2]s/oauth2/sessions/logout"
+  "end_session_endpoint": "%[1]s://%[2]s/oauth2/sessions/logout",
+  "tls_client_certificate_bound_access_tokens": true,
+  "mtls_endpoint_aliases": {
+    "token_endpoint": "%[1]s://%[2]s/oauth2/mtls/token"
+  }
 }`, scheme, host)
 }
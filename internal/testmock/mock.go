@@ -2,14 +2,20 @@
 package testmock
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"html/template"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/justinas/alice"
@@ -19,47 +25,61 @@ import (
 
 const testKey = "MIICdgIBADANBgkqhkiG9w0BAQEFAASCAmAwggJcAgEAAoGBAMFHqgKhS4nHlTE5P0IauictV+9TtSVgIiC+aWVDqc41hNE1b30Tk/rTNv7AR1gVGnF0YCnxQ4o59b5KQriJmXFmPs/P8exyLXxDDEEQ34aSwJOTxBKKg/0U2JRmAA8QwAxa3jBg7X7ijMRR3hqWmjnd/kt4nn0uC0QnRSY6t6SRAgMBAAECgYB9RCAYokcd3f+ArpSkGERr3cRvNTZjKeIUjLQsUGU+Y4tYOCSw0L6IwtmS1DWpDcxcmcs1g8t9S8FMej6x8WRDa4HpSDbriU7wK1Om2hIn1izm0fNT6QJBhD4hY6mhXatrAy7CRa9jEoDU0pxh2NpxFm7apoLURSVq8BkCqFY1UQJBAPlse9wWjgwKebmRP6HqUr6NRR472z1nblokAeMVpLzKekRrzPvuUa1PApzt9WazgUsVSlWBCu+rfSxBAFCRV3UCQQDGYDsMRXiLBQQsv75JlwSWMgjoVb13yEDw3eVqQLX40z4K42YxxlSn5RWZ23CDF1qTjKUhtTQLXOPJboiR2pEtAkEAz44+477BJbPx50G/OfXMNVVJlwcoQci4Q7qC930jQRcc96LdSSfgP9/nxL8f3v6xMNHesZhYiWijGRheMq0/oQJAKPtKV4+mhnnD0gbOpd9H+Etf4beMy8kX+Wqt8VRrA3uIbrFptFC3vnOqEb3usXZKpP7CQoNvvAU1nbBzEEaqBQJAJbsctoC7k0BUsLFASyXkJqplCDmzukvfd4wmbRHlivmLqbMORvLHccYZHqwfSjUQ5pGWPXM4sNx4O2WibBu+xQ=="
 
+const testKeyID = "test-key"
+
 // Serve creates a simple authentication server that returns pre-canned responses
 // to test the oauth flow
 func Serve() *httptest.Server {
+	return serve(func(server *httptest.Server) {
+		server.Start()
+	})
+}
+
+// ServeTLS is Serve over HTTPS with a self-signed certificate for 127.0.0.1,
+// the certificate is returned by the server's Certificate method. Clients may
+// present a certificate, the mTLS token endpoint then issues access tokens
+// bound to it.
+func ServeTLS() *httptest.Server {
+	return serve(func(server *httptest.Server) {
+		server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+		server.StartTLS()
+	})
+}
+
+func serve(start func(*httptest.Server)) *httptest.Server {
 	mux := http.NewServeMux()
 
 	get := alice.New(assertGet)
 	post := alice.New(assertPost)
 
-	handleToken := &tokenHandler{}
+	codes := &codeStore{codes: map[string]url.Values{}}
+	devices := &deviceStore{devices: map[string]*device{}}
+	tokens := &tokenIssuer{}
+	access := &accessStore{tokens: map[string]*opaqueToken{}}
+	handleAuth := &authHandler{codes: codes, access: access, tokens: tokens}
+	refreshTokens := &refreshStore{tokens: map[string]*refreshToken{}, revoked: map[string]bool{}}
+	handleToken := &tokenHandler{codes: codes, devices: devices, access: access, refreshTokens: refreshTokens, tokens: tokens}
 
 	mux.Handle("/.well-known/openid-configuration", get.ThenFunc(handleWellKnownMetadata))
-	mux.Handle("/oauth2/auth", get.ThenFunc(handleAuth))
+	mux.Handle("/.well-known/jwks.json", get.ThenFunc(handleJWKS))
+	mux.Handle("/oauth2/auth", get.Then(handleAuth))
 	mux.Handle("/oauth2/token", post.Then(handleToken))
+	mux.Handle("/oauth2/mtls/token", post.Then(handleToken))
+	mux.Handle("/oauth2/device/auth", post.Then(&deviceAuthHandler{devices: devices}))
+	mux.Handle("/oauth2/device/verify", get.Then(&deviceVerifyHandler{devices: devices}))
+	mux.Handle("/oauth2/introspect", post.Then(&introspectionHandler{access: access, refreshTokens: refreshTokens, tokens: tokens}))
+	mux.Handle("/oauth2/revoke", post.Then(&revocationHandler{access: access, refreshTokens: refreshTokens}))
+	mux.Handle("/oauth2/sessions/logout", &endSessionHandler{tokens: tokens})
+	mux.Handle("/userinfo", &userInfoHandler{access: access, tokens: tokens})
 	mux.HandleFunc("/", handleNotFound)
 
-	server := httptest.NewServer(mux)
-	handleToken.issuer = server.URL
+	server := httptest.NewUnstartedServer(mux)
+	start(server)
+	tokens.issuer = server.URL + "/"
 
 	return server
 }
 
-// OpenURL mimics the standard client browser by following the redirects.
-// This is designed to work with testmock.Serve and expects every request
-// to return a redirect except the last request.
-func OpenURL(url string) error {
-	// http.Get will automatically follow up to 10 redirects
-	// which is enough for the oauth dance.
-	res, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != 200 {
-		buf := new(strings.Builder)
-		io.Copy(buf, res.Body)
-		return fmt.Errorf("server response %s : %s", res.Status, buf.String())
-	}
-	return nil
-}
-
 func handleNotFound(w http.ResponseWriter, r *http.Request) {
 	url := r.URL.String()
 	msg := fmt.Sprintf("URL [%s] not found for [%s]", url, r.Method)
@@ -69,14 +89,51 @@ func handleNotFound(w http.ResponseWriter, r *http.Request) {
 func handleWellKnownMetadata(w http.ResponseWriter, r *http.Request) {
 	scheme := "http"
 	if r.TLS != nil {
-		scheme = "http"
+		scheme = "https"
 	}
 	body := wellKnownMetadata(scheme, r.Host)
 	writeJSONString(w, body)
 }
 
-func handleAuth(w http.ResponseWriter, r *http.Request) {
-	// TODO: capture the query string for instrospection
+// codeStore remembers the authorization request that each code was issued
+// for so the token endpoint can check the PKCE verifier.
+type codeStore struct {
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func (s *codeStore) issue(q url.Values) (string, error) {
+	code, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[code] = q
+
+	return code, nil
+}
+
+// redeem returns the authorization request for the code, a code can
+// only be redeemed once.
+func (s *codeStore) redeem(code string) (url.Values, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.codes[code]
+	delete(s.codes, code)
+
+	return q, ok
+}
+
+type authHandler struct {
+	codes  *codeStore
+	access *accessStore
+	tokens *tokenIssuer
+}
+
+func (h authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	callback := q.Get("redirect_uri")
 	if callback == "" {
@@ -84,88 +141,441 @@ func handleAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	responseType := strings.Fields(q.Get("response_type"))
+
+	var response url.Values
+	if q.Get("prompt") == "none" {
+		// there are no sessions in the mock so the user always needs to login
+		// https://openid.net/specs/openid-connect-core-1_0.html#AuthError
+		response = url.Values{
+			"error":             {"login_required"},
+			"error_description": {"the user must login, prompt=none is not possible"},
+		}
+	} else {
+		var err error
+		response, err = h.issue(q, responseType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if state := q.Get("state"); state != "" {
+		response.Set("state", state)
+	}
+
+	// https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#ResponseModes
+	// tokens default to the fragment so they are not sent to the server
+	defaultMode := "query"
+	if contains(responseType, "token") || contains(responseType, "id_token") {
+		defaultMode = "fragment"
+	}
+
+	mode := q.Get("response_mode")
+	if mode == "" {
+		mode = defaultMode
+	}
+
+	// https://openid.net/specs/oauth-v2-jarm.html#section-2.3
+	if mode == "jwt" || strings.HasSuffix(mode, ".jwt") {
+		token, err := h.tokens.jarm(q, response)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not sign jwt : %v", err), http.StatusInternalServerError)
+			return
+		}
+		response = url.Values{"response": {token}}
+
+		mode = strings.TrimSuffix(mode, "jwt")
+		mode = strings.TrimSuffix(mode, ".")
+		if mode == "" {
+			mode = defaultMode
+		}
+	}
+
 	// no error checking here for simplicity
 	// assume URL does not already contain a query string
-	callback += "?code=token-please"
+	switch mode {
+	case "fragment":
+		http.Redirect(w, r, callback+"#"+response.Encode(), http.StatusSeeOther)
+	case "form_post":
+		writeFormPost(w, callback, response)
+	default:
+		http.Redirect(w, r, callback+"?"+response.Encode(), http.StatusSeeOther)
+	}
+}
+
+// issue creates the code and tokens for the response type.
+func (h authHandler) issue(q url.Values, responseType []string) (url.Values, error) {
+	response := url.Values{}
+
+	if contains(responseType, "code") {
+		code, err := h.codes.issue(q)
+		if err != nil {
+			return nil, fmt.Errorf("could not issue code : %w", err)
+		}
+		response.Set("code", code)
+	}
 
-	http.Redirect(w, r, callback, http.StatusSeeOther)
+	if contains(responseType, "token") {
+		access, err := h.access.issue(q, "")
+		if err != nil {
+			return nil, fmt.Errorf("could not issue access token : %w", err)
+		}
+		response.Set("access_token", access)
+		response.Set("token_type", "Bearer")
+		response.Set("expires_in", fmt.Sprint(uint32(accessTokenLifetime/time.Second)))
+	}
+
+	if contains(responseType, "id_token") {
+		token, err := h.tokens.idToken(q, response.Get("code"), response.Get("access_token"))
+		if err != nil {
+			return nil, fmt.Errorf("could not sign jwt : %w", err)
+		}
+		response.Set("id_token", token)
+	}
+
+	return response, nil
+}
+
+var formPostPage = template.Must(template.New("form_post").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Submit This Form</title></head>
+<body onload="javascript:document.forms[0].submit()">
+<form method="post" action="{{.Action}}">
+{{range $name, $values := .Params}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}"/>
+{{end}}{{end}}</form>
+</body>
+</html>
+`))
+
+// writeFormPost responds with a page that posts the response to the callback,
+// https://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html
+func writeFormPost(w http.ResponseWriter, callback string, response url.Values) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	formPostPage.Execute(w, struct {
+		Action string
+		Params url.Values
+	}{callback, response})
+}
+
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	key, err := loadTestKey()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not load signing key : %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	private := key.Key.(jose.JSONWebKey)
+	jwk := private.Public()
+	jwk.Use = "sig"
+	writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk}})
 }
 
 type tokenResponse struct {
-	TokenType    string   `json:"token_type,omitempty"`
-	IDToken      string   `json:"id_token,omitempty"`
-	AccessToken  string   `json:"access_token,omitempty"`
-	RefreshToken string   `json:"refresh_token,omitempty"`
-	Scope        []string `json:"scope,omitempty"`
-	ExpiresIn    uint32   `json:"expires_in,omitempty"`
+	TokenType    string `json:"token_type,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	ExpiresIn    uint32 `json:"expires_in,omitempty"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
 }
 
 type tokenHandler struct {
-	issuer string
+	codes         *codeStore
+	devices       *deviceStore
+	access        *accessStore
+	refreshTokens *refreshStore
+	tokens        *tokenIssuer
 }
 
 func (h tokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// TODO: capture form values for introspection by tests
-	// https://tools.ietf.org/html/rfc6749#section-4.1.3
-	//	grant_type (should be authorization_code)
-	//	code
-	// 	redirect_uri
-	//	client_id
+	switch grant := r.PostFormValue("grant_type"); grant {
+	case "authorization_code":
+		h.authorizationCode(w, r)
+	case deviceCodeGrant:
+		h.deviceCode(w, r)
+	case "client_credentials":
+		h.clientCredentials(w, r)
+	case "refresh_token":
+		h.refresh(w, r)
+	default:
+		writeError(w, "unsupported_grant_type", fmt.Sprintf("grant_type [%s] is not supported", grant))
+	}
+}
 
-	key, err := loadTestKey()
+// authorizationCode redeems a code issued by the authorization endpoint,
+// https://tools.ietf.org/html/rfc6749#section-4.1.3
+func (h tokenHandler) authorizationCode(w http.ResponseWriter, r *http.Request) {
+	auth, ok := h.codes.redeem(r.PostFormValue("code"))
+	if !ok {
+		writeError(w, "invalid_grant", "unknown code")
+		return
+	}
+
+	err := authenticateClient(r, auth.Get("client_id"))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not load signing key : %v", err), http.StatusInternalServerError)
+		writeError(w, "invalid_client", err.Error())
+		return
+	}
+
+	err = verifyPKCE(auth, r.PostFormValue("code_verifier"))
+	if err != nil {
+		writeError(w, "invalid_grant", err.Error())
+		return
+	}
+
+	h.issue(w, r, auth, "")
+}
+
+// clientCredentials issues an access token to the client itself, there is
+// no ID token or refresh token, https://tools.ietf.org/html/rfc6749#section-4.4
+func (h tokenHandler) clientCredentials(w http.ResponseWriter, r *http.Request) {
+	if !hasClientCredentials(r) {
+		writeError(w, "invalid_client", "client_credentials requires client authentication")
+		return
+	}
+
+	clientID := requestClientID(r)
+	err := authenticateClient(r, clientID)
+	if err != nil {
+		writeError(w, "invalid_client", err.Error())
+		return
+	}
+
+	auth := url.Values{
+		"client_id": {clientID},
+		"scope":     {r.PostFormValue("scope")},
+		"resource":  r.PostForm["resource"],
+		"audience":  r.PostForm["audience"],
+	}
+
+	var cert *x509.Certificate
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cert = r.TLS.PeerCertificates[0]
+	}
+
+	access, err := h.tokens.accessToken(auth, clientID, cert)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not sign jwt : %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, tokenResponse{
+		TokenType:   "Bearer",
+		AccessToken: access,
+		Scope:       grantedScope(auth.Get("scope")),
+		ExpiresIn:   uint32(accessTokenLifetime / time.Second),
+	})
+}
+
+// issue writes the token response for the authorization request auth. The
+// refresh token is added to family, or a new family when family is empty.
+func (h tokenHandler) issue(w http.ResponseWriter, r *http.Request, auth url.Values, family string) {
+	refresh, err := h.refreshTokens.issue(auth, family)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not issue refresh token : %v", err), http.StatusInternalServerError)
 		return
 	}
+	if family == "" {
+		family = refresh
+	}
+
+	// A client certificate gets a certificate bound access token,
+	// https://tools.ietf.org/html/rfc8705#section-3
+	var access string
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		access, err = h.tokens.accessToken(auth, "someone@test", r.TLS.PeerCertificates[0])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not sign jwt : %v", err), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		access, err = h.access.issue(auth, family)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not issue access token : %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	token, err := h.tokens.idToken(auth, "", access)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not sign jwt : %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := tokenResponse{
+		TokenType:    "Bearer",
+		IDToken:      token,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		Scope:        grantedScope(auth.Get("scope")),
+		ExpiresIn:    uint32(accessTokenLifetime / time.Second),
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, response)
+}
+
+const accessTokenLifetime = 5 * time.Minute
+
+// tokenIssuer signs the ID tokens issued by both the authorization
+// and token endpoints.
+type tokenIssuer struct {
+	issuer string
+}
+
+// idToken issues an ID token for the authorization request. When the
+// token is issued alongside a code or access token their hashes are
+// included as c_hash and at_hash.
+func (i *tokenIssuer) idToken(auth url.Values, code, accessToken string) (string, error) {
+	key, err := loadTestKey()
+	if err != nil {
+		return "", fmt.Errorf("could not load signing key : %w", err)
+	}
 
 	opt := new(jose.SignerOptions).WithType("JWT")
 	sig, err := jose.NewSigner(key, opt)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not create signer : %v", err), http.StatusInternalServerError)
-		return
+		return "", fmt.Errorf("could not create signer : %w", err)
+	}
+
+	// There are no sessions in the mock, each ID token gets its own sid
+	// so back-channel logout tokens can refer to it.
+	sid, err := randomString()
+	if err != nil {
+		return "", err
 	}
 
+	// mock_nbf is a duration the ID token is not valid for, to test how
+	// a token that is not yet valid is reported.
 	now := time.Now()
+	notBefore := now
+	if offset, err := time.ParseDuration(auth.Get("mock_nbf")); err == nil {
+		notBefore = now.Add(offset)
+	}
+
 	claims := jwt.Claims{
-		Issuer:    h.issuer,
-		Audience:  jwt.Audience{"http://target.test/"},
+		Issuer:    i.issuer,
+		Audience:  jwt.Audience{auth.Get("client_id")},
 		IssuedAt:  jwt.NewNumericDate(now),
-		NotBefore: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		NotBefore: jwt.NewNumericDate(notBefore),
 		Expiry:    jwt.NewNumericDate(now.Add(10 * time.Minute)),
 		Subject:   "someone@test",
 	}
-	group := struct {
-		Group []string `json:"group,omitempty"`
+	extra := struct {
+		Nonce  string   `json:"nonce,omitempty"`
+		AtHash string   `json:"at_hash,omitempty"`
+		CHash  string   `json:"c_hash,omitempty"`
+		Sid    string   `json:"sid,omitempty"`
+		Group  []string `json:"group,omitempty"`
 	}{
+		Nonce:  auth.Get("nonce"),
+		Sid:    sid,
+		AtHash: halfHash(accessToken),
+		CHash:  halfHash(code),
 		Group: []string{
 			"devs@test",
 			"users@test",
 		},
 	}
 
-	token, err := jwt.
+	return jwt.
 		Signed(sig).
 		Claims(claims).
-		Claims(group).
+		Claims(extra).
 		CompactSerialize()
+}
 
+// accessToken issues a JWT access token for subject, https://tools.ietf.org/html/rfc9068
+// The audience is the resource or audience param of the request, defaulting
+// to the mock's API. When cert is not nil the token is bound to it,
+// https://tools.ietf.org/html/rfc8705#section-3.1
+func (i *tokenIssuer) accessToken(auth url.Values, subject string, cert *x509.Certificate) (string, error) {
+	key, err := loadTestKey()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not sign jwt : %v", err), http.StatusInternalServerError)
-		return
+		return "", fmt.Errorf("could not load signing key : %w", err)
 	}
 
-	response := tokenResponse{
-		TokenType:    "Bearer",
-		IDToken:      token,
-		AccessToken:  "let-me-in",
-		RefreshToken: "another-token-please",
-		Scope:        []string{"openid"},
-		ExpiresIn:    uint32((5 * time.Minute) / time.Second),
+	sig, err := jose.NewSigner(key, new(jose.SignerOptions).WithType("at+jwt"))
+	if err != nil {
+		return "", fmt.Errorf("could not create signer : %w", err)
 	}
 
-	w.Header().Set("Cache-Control", "no-store")
-	w.Header().Set("Pragma", "no-cache")
-	writeJSON(w, response)
+	jti, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	aud := jwt.Audience{i.issuer + "api"}
+	if requested := append(auth["resource"], auth["audience"]...); len(requested) > 0 {
+		aud = requested
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:   i.issuer,
+		Audience: aud,
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(accessTokenLifetime)),
+		Subject:  subject,
+		ID:       jti,
+	}
+
+	extra := map[string]interface{}{
+		"client_id": auth.Get("client_id"),
+		"scope":     grantedScope(auth.Get("scope")),
+	}
+	if cert != nil {
+		sum := sha256.Sum256(cert.Raw)
+		extra["cnf"] = map[string]string{
+			"x5t#S256": base64.RawURLEncoding.EncodeToString(sum[:]),
+		}
+	}
+
+	return jwt.Signed(sig).Claims(claims).Claims(extra).CompactSerialize()
+}
+
+// jarm wraps the authorization response in a signed JWT,
+// https://openid.net/specs/oauth-v2-jarm.html#section-2.1
+func (i *tokenIssuer) jarm(auth url.Values, response url.Values) (string, error) {
+	key, err := loadTestKey()
+	if err != nil {
+		return "", fmt.Errorf("could not load signing key : %w", err)
+	}
+
+	sig, err := jose.NewSigner(key, new(jose.SignerOptions).WithType("JWT"))
+	if err != nil {
+		return "", fmt.Errorf("could not create signer : %w", err)
+	}
+
+	claims := map[string]interface{}{}
+	for k := range response {
+		claims[k] = response.Get(k)
+	}
+	claims["iss"] = i.issuer
+	claims["aud"] = auth.Get("client_id")
+	claims["exp"] = jwt.NewNumericDate(time.Now().Add(10 * time.Minute))
+
+	return jwt.Signed(sig).Claims(claims).CompactSerialize()
+}
+
+// halfHash computes at_hash and c_hash values for the PS256 test key,
+// https://openid.net/specs/openid-connect-core-1_0.html#CodeIDToken
+func halfHash(value string) string {
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
 }
 
 var (
@@ -197,11 +607,148 @@ func writeJSON(w http.ResponseWriter, data interface{}) {
 	e.Encode(data)
 }
 
+// writeError writes an OAuth error response as described in
+// https://tools.ietf.org/html/rfc6749#section-5.2
+func writeError(w http.ResponseWriter, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.Encode(errorResponse{code, description})
+}
+
 func writeJSONString(w http.ResponseWriter, json string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(json))
 }
 
+// verifyPKCE checks the code_verifier against the challenge sent in the
+// authorization request, https://tools.ietf.org/html/rfc7636#section-4.6
+func verifyPKCE(auth url.Values, verifier string) error {
+	challenge := auth.Get("code_challenge")
+	if challenge == "" {
+		if verifier != "" {
+			return errors.New("code_verifier sent without a code_challenge")
+		}
+		return nil
+	}
+
+	if verifier == "" {
+		return errors.New("code_verifier required")
+	}
+
+	switch method := auth.Get("code_challenge_method"); method {
+	case "", "plain":
+	case "S256":
+		sum := sha256.Sum256([]byte(verifier))
+		verifier = base64.RawURLEncoding.EncodeToString(sum[:])
+	default:
+		return fmt.Errorf("unsupported code_challenge_method [%s]", method)
+	}
+
+	if verifier != challenge {
+		return errors.New("code_verifier does not match code_challenge")
+	}
+
+	return nil
+}
+
+const clientAssertionJWT = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// authenticateClient checks the client authenticated as the client the code
+// was issued to. There are no client registrations in the mock so secrets are
+// not checked and the client assertion signature is not verified, only its
+// claims, https://tools.ietf.org/html/rfc7523#section-3
+func authenticateClient(r *http.Request, clientID string) error {
+	if id := requestClientID(r); id != clientID {
+		return fmt.Errorf("client [%s] does not match the grant", id)
+	}
+
+	raw := r.PostFormValue("client_assertion")
+	if raw == "" {
+		return nil
+	}
+
+	if t := r.PostFormValue("client_assertion_type"); t != clientAssertionJWT {
+		return fmt.Errorf("unsupported client_assertion_type [%s]", t)
+	}
+
+	token, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return fmt.Errorf("invalid client_assertion : %w", err)
+	}
+
+	var claims jwt.Claims
+	err = token.UnsafeClaimsWithoutVerification(&claims)
+	if err != nil {
+		return fmt.Errorf("invalid client_assertion : %w", err)
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	tokenURL := scheme + "://" + r.Host + r.URL.Path
+
+	switch {
+	case claims.Issuer != clientID || claims.Subject != clientID:
+		return fmt.Errorf("client_assertion iss [%s] and sub [%s] must be the client_id", claims.Issuer, claims.Subject)
+	case claims.ID == "":
+		return errors.New("client_assertion does not contain a jti")
+	}
+
+	return claims.ValidateWithLeeway(jwt.Expected{Audience: jwt.Audience{tokenURL}, Time: time.Now()}, 0)
+}
+
+// hasClientCredentials reports if the request includes any form of client
+// authentication, public clients only send their client_id.
+func hasClientCredentials(r *http.Request) bool {
+	_, _, basic := r.BasicAuth()
+	mtls := r.TLS != nil && len(r.TLS.PeerCertificates) > 0
+	return basic || mtls || r.PostFormValue("client_secret") != "" || r.PostFormValue("client_assertion") != ""
+}
+
+// requestClientID returns the client from the Authorization header or,
+// when there is no header, the client_id param.
+func requestClientID(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		user, _ = url.QueryUnescape(user)
+		return user
+	}
+	return r.PostFormValue("client_id")
+}
+
+// grantedScope drops any requested scopes that are not
+// listed in scopes_supported.
+func grantedScope(requested string) string {
+	var granted []string
+	for _, s := range strings.Fields(requested) {
+		switch s {
+		case "openid", "offline", "offline_access":
+			granted = append(granted, s)
+		}
+	}
+	return strings.Join(granted, " ")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 func loadTestKey() (jose.SigningKey, error) {
 	der, err := base64.StdEncoding.DecodeString(testKey)
 	if err != nil {
@@ -213,5 +760,11 @@ func loadTestKey() (jose.SigningKey, error) {
 		return jose.SigningKey{}, err
 	}
 
-	return jose.SigningKey{Algorithm: jose.PS256, Key: key}, nil
+	jwk := jose.JSONWebKey{
+		Key:       key,
+		KeyID:     testKeyID,
+		Algorithm: string(jose.PS256),
+	}
+
+	return jose.SigningKey{Algorithm: jose.PS256, Key: jwk}, nil
 }
@@ -0,0 +1,88 @@
+package testmock
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// refreshToken is an issued refresh token, tokens from the same login
+// share a family.
+type refreshToken struct {
+	auth   url.Values
+	family string
+	used   bool
+}
+
+// refreshStore rotates refresh tokens, a token can only be used once.
+// Reusing a token revokes every token in its family as described in
+// https://tools.ietf.org/html/draft-ietf-oauth-security-topics-16#section-4.13.2
+type refreshStore struct {
+	mu      sync.Mutex
+	tokens  map[string]*refreshToken
+	revoked map[string]bool
+}
+
+// issue creates a refresh token for auth in family, an empty family
+// starts a new one.
+func (s *refreshStore) issue(auth url.Values, family string) (string, error) {
+	token, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	if family == "" {
+		family = token
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = &refreshToken{auth: auth, family: family}
+
+	return token, nil
+}
+
+// redeem marks the token as used and returns it, nil when the token is
+// unknown, revoked or has already been used.
+func (s *refreshStore) redeem(token string) *refreshToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[token]
+	switch {
+	case !ok || s.revoked[t.family]:
+		return nil
+	case t.used:
+		s.revoked[t.family] = true
+		return nil
+	default:
+		t.used = true
+		return t
+	}
+}
+
+// refresh issues new tokens for a refresh token,
+// https://tools.ietf.org/html/rfc6749#section-6
+func (h tokenHandler) refresh(w http.ResponseWriter, r *http.Request) {
+	t := h.refreshTokens.redeem(r.PostFormValue("refresh_token"))
+	if t == nil {
+		writeError(w, "invalid_grant", "unknown, revoked or reused refresh_token")
+		return
+	}
+
+	err := authenticateClient(r, t.auth.Get("client_id"))
+	if err != nil {
+		writeError(w, "invalid_client", err.Error())
+		return
+	}
+
+	// A refreshed ID token does not include the nonce,
+	// https://openid.net/specs/openid-connect-core-1_0.html#RefreshTokenResponse
+	auth := url.Values{}
+	for k, v := range t.auth {
+		auth[k] = v
+	}
+	auth.Del("nonce")
+
+	h.issue(w, r, auth, t.family)
+}
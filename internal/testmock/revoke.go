@@ -0,0 +1,100 @@
+package testmock
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// revoke removes the access token, an error when the token belongs to
+// another client. Unknown tokens are ignored.
+func (s *accessStore) revoke(token, clientID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[token]
+	switch {
+	case !ok:
+		return nil
+	case t.auth.Get("client_id") != clientID:
+		return fmt.Errorf("the token was not issued to client [%s]", clientID)
+	default:
+		delete(s.tokens, token)
+		return nil
+	}
+}
+
+// revokeFamily removes the access tokens issued alongside the refresh
+// tokens in family.
+func (s *accessStore) revokeFamily(family string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, t := range s.tokens {
+		if t.family == family {
+			delete(s.tokens, token)
+		}
+	}
+}
+
+// revoke revokes every refresh token in the token's family and returns
+// the family, empty when the token is unknown.
+func (s *refreshStore) revoke(token, clientID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[token]
+	switch {
+	case !ok:
+		return "", nil
+	case t.auth.Get("client_id") != clientID:
+		return "", fmt.Errorf("the token was not issued to client [%s]", clientID)
+	default:
+		s.revoked[t.family] = true
+		return t.family, nil
+	}
+}
+
+type revocationHandler struct {
+	access        *accessStore
+	refreshTokens *refreshStore
+}
+
+// ServeHTTP revokes the token, https://tools.ietf.org/html/rfc7009#section-2.1
+// Revoking a refresh token also revokes the access tokens issued with it.
+// JWT access tokens can not be revoked, they are valid until they expire.
+func (h revocationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	clientID := requestClientID(r)
+	if clientID == "" {
+		writeError(w, "invalid_client", "client_id is required")
+		return
+	}
+
+	err := authenticateClient(r, clientID)
+	if err != nil {
+		writeError(w, "invalid_client", err.Error())
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if _, err := jwt.ParseSigned(token); err == nil {
+		writeError(w, "unsupported_token_type", "JWT access tokens can not be revoked")
+		return
+	}
+
+	family, err := h.refreshTokens.revoke(token, clientID)
+	if err == nil {
+		err = h.access.revoke(token, clientID)
+	}
+	if err != nil {
+		writeError(w, "unauthorized_client", err.Error())
+		return
+	}
+
+	if family != "" {
+		h.access.revokeFamily(family)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,87 @@
+package testmock
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type userInfoHandler struct {
+	access *accessStore
+	tokens *tokenIssuer
+}
+
+// ServeHTTP returns the claims for the bearer token, as JSON for opaque
+// access tokens and as a signed JWT for JWT access tokens so both response
+// types can be tested, https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+func (h userInfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method [%s] not allowed for URL [%s]", r.Method, r.URL.String()), http.StatusMethodNotAllowed)
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="testmock"`)
+		http.Error(w, "bearer token required", http.StatusUnauthorized)
+		return
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+
+	claims := map[string]interface{}{
+		"sub":   "someone@test",
+		"name":  "Someone",
+		"email": "someone@test",
+		"group": []string{"devs@test", "users@test"},
+	}
+
+	if h.access.lookup(token) != nil {
+		writeJSON(w, claims)
+		return
+	}
+
+	var at jwt.Claims
+	var extra struct {
+		ClientID string `json:"client_id"`
+	}
+	parsed, err := jwt.ParseSigned(token)
+	if err == nil {
+		err = parsed.UnsafeClaimsWithoutVerification(&at, &extra)
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="unknown access token"`)
+		http.Error(w, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+
+	claims["sub"] = at.Subject
+	claims["iss"] = h.tokens.issuer
+	claims["aud"] = extra.ClientID
+
+	signed, err := h.tokens.sign("JWT", claims)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not sign jwt : %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwt")
+	w.Write([]byte(signed))
+}
+
+// sign signs the claims with the test key, typ is the JOSE header type.
+func (i *tokenIssuer) sign(typ string, claims interface{}) (string, error) {
+	key, err := loadTestKey()
+	if err != nil {
+		return "", fmt.Errorf("could not load signing key : %w", err)
+	}
+
+	sig, err := jose.NewSigner(key, new(jose.SignerOptions).WithType(jose.ContentType(typ)))
+	if err != nil {
+		return "", fmt.Errorf("could not create signer : %w", err)
+	}
+
+	return jwt.Signed(sig).Claims(claims).CompactSerialize()
+}